@@ -0,0 +1,17 @@
+//go:build release
+
+package frontend
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// the frontend must be built before catnip, the build fails otherwise
+//
+//go:embed all:dist
+var embedded embed.FS
+
+func init() {
+	dist, _ = fs.Sub(embedded, "dist")
+}
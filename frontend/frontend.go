@@ -0,0 +1,31 @@
+// Package frontend provides the pages and assets of catnip.
+package frontend
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist is only set in release builds, see dist_release.go.
+var dist fs.FS
+
+//go:embed assets/favicon.ico locales/*.json
+var sources embed.FS
+
+// Dist returns the output of the frontend build. It is embedded into release builds only, other builds return nil and
+// serve the frontend from disk.
+func Dist() fs.FS {
+	return dist
+}
+
+// Favicon returns the file system containing favicon.ico, which is served as it is.
+func Favicon() fs.FS {
+	favicon, _ := fs.Sub(sources, "assets")
+	return favicon
+}
+
+// Locales returns the file system containing the message catalogs of the pages, e.g. de.json.
+func Locales() fs.FS {
+	locales, _ := fs.Sub(sources, "locales")
+	return locales
+}
@@ -6,30 +6,153 @@ interface StatusResponse {
 	deploymentStatus: DeploymentStatus;
 	redirectUrl: string;
 	errorMessage: string;
+	projectUuid?: string;
+	projectName?: string;
+	versionUuid?: string;
+	versionName?: string;
+	desiredState?: string;
+	deployment?: {
+		status: string;
+		timestamp: string;
+	};
+	queuePosition?: number;
+	stale?: boolean;
+	members?: MemberStatus[];
+	estimatedReadyAt?: string;
+}
+
+/**
+ * Another version of the groups of the version being woken up. The version is only Ready once all required members
+ * are ready as well.
+ */
+interface MemberStatus {
+	deploymentStatus: DeploymentStatus;
+	projectName: string;
+	versionUuid: string;
+	versionName: string;
+	required: boolean;
+	queuePosition?: number;
+}
+
+interface WakeupResponse {
+	triggered: boolean;
+	queuePosition?: number;
 }
 
 interface WakeupPageComponent {
 	currentStatus: StatusResponse;
 	deploymentUrl: string;
+	csrfToken: string;
+	now: number;
+	start: () => void;
+	estimate: () => string;
+	wakeUp: () => Promise<void>;
+	watchDeploymentStatus: () => void;
 	checkDeploymentStatus: () => void;
 	redirectAfterDelay: () => void;
 	redirectToDeployment: () => void;
 	retry: () => void;
 }
 
+/**
+ * Browsers keep the fragment of the deployment URL when following the redirect to this page, but it is never sent to
+ * the server. It is therefore restored from the location of the wakeup page unless redirectTo has a fragment itself.
+ */
+function getDeploymentUrl(): string {
+	const redirectTo = new URLSearchParams(location.search).get("redirectTo") || "";
+	if (redirectTo === "" || redirectTo.includes("#")) {
+		return redirectTo;
+	}
+	return redirectTo + location.hash;
+}
+
 const component: WakeupPageComponent = {
-	deploymentUrl: new URLSearchParams(location.search).get("redirectTo") || "",
+	deploymentUrl: getDeploymentUrl(),
+	// only set once the user confirmed the wake-up of a project asking for a confirmation
+	csrfToken: document.querySelector<HTMLElement>("[data-csrf-token]")?.dataset.csrfToken || "",
 	currentStatus: {
 		deploymentStatus: "Pending",
 		redirectUrl: "",
 		errorMessage: ""
 	},
+	now: Date.now(),
+	start() {
+		this.wakeUp().finally(() => this.watchDeploymentStatus());
+		setInterval(() => this.now = Date.now(), 1000);
+	},
+	/**
+	 * Describes when the deployment is expected to be ready, e.g. "in 2 minutes", in the language of the page. It is
+	 * empty if the server has no estimate based on previous wake-ups.
+	 */
+	estimate() {
+		if (!this.currentStatus.estimatedReadyAt) {
+			return "";
+		}
+		const seconds = Math.max(0, Math.round((Date.parse(this.currentStatus.estimatedReadyAt) - this.now) / 1000));
+		const format = new Intl.RelativeTimeFormat(document.documentElement.lang || undefined, {numeric: "auto"});
+		if (seconds < 60) {
+			return format.format(seconds, "second");
+		}
+		return format.format(Math.round(seconds / 60), "minute");
+	},
+	wakeUp() {
+		if (this.deploymentUrl === "") {
+			return Promise.resolve();
+		}
+
+		// loading the page has no side effects, the deployment is woken up here; triggering is idempotent, so reloads are fine
+		const headers: Record<string, string> = this.csrfToken === "" ? {} : {"oneko-catnip-csrf-token": this.csrfToken};
+		return fetch(`/api/wakeup?deploymentUrl=${encodeURIComponent(this.deploymentUrl)}`, {method: "POST", headers})
+			.then(response => {
+				if (!response.ok) {
+					console.log("failed to wake up the deployment");
+					return;
+				}
+				return response.json().then((wakeup: WakeupResponse) => {
+					this.currentStatus.queuePosition = wakeup.queuePosition;
+				});
+			})
+			.catch(() => console.log("failed to wake up the deployment"));
+	},
+	/**
+	 * Receives status changes from the server as they happen. Falls back to polling if the browser does not support
+	 * server-sent events or the stream fails.
+	 */
+	watchDeploymentStatus() {
+		if (this.deploymentUrl === "") {
+			return;
+		}
+		if (!("EventSource" in window)) {
+			this.checkDeploymentStatus();
+			return;
+		}
+
+		const source = new EventSource(`/api/status/stream?deploymentUrl=${encodeURIComponent(this.deploymentUrl)}`);
+		source.addEventListener("status", (event: MessageEvent) => {
+			const response: StatusResponse = JSON.parse(event.data);
+			this.currentStatus = response;
+
+			if (response.deploymentStatus == "Error") {
+				console.log("failed to check deployment status: " + response.errorMessage);
+			}
+
+			if (response.deploymentStatus == "Ready") {
+				source.close();
+				this.redirectAfterDelay();
+			}
+		});
+		source.onerror = () => {
+			console.log("deployment status stream failed, falling back to polling");
+			source.close();
+			this.checkDeploymentStatus();
+		};
+	},
 	checkDeploymentStatus() {
 		if (this.deploymentUrl === "") {
 			return;
 		}
 
-		fetch(`/api/status?deploymentUrl=${this.deploymentUrl}`, {method: "GET"})
+		fetch(`/api/status?deploymentUrl=${encodeURIComponent(this.deploymentUrl)}`, {method: "GET"})
 			.then(response => {
 					if (response.status > 500) {
 						console.log("failed to get deployment status");
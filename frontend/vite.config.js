@@ -1,14 +1,47 @@
 // vite.config.js
 import {resolve} from 'path'
+import {readdirSync, readFileSync, statSync, writeFileSync} from 'fs'
+import {brotliCompressSync, gzipSync} from 'zlib'
 import {defineConfig} from 'vite'
 
+// precompress writes gzip and brotli compressed variants of the larger assets next to them, catnip sends them to
+// clients accepting them
+function precompress() {
+	const compressible = /\.(js|css|svg|json|ttf|ico)$/
+	const minSize = 1024
+	const compress = (dir) => {
+		for (const name of readdirSync(dir)) {
+			const path = resolve(dir, name)
+			if (statSync(path).isDirectory()) {
+				compress(path)
+			} else if (compressible.test(name) && statSync(path).size >= minSize) {
+				const content = readFileSync(path)
+				writeFileSync(`${path}.gz`, gzipSync(content, {level: 9}))
+				writeFileSync(`${path}.br`, brotliCompressSync(content))
+			}
+		}
+	}
+	return {
+		name: 'precompress',
+		apply: 'build',
+		closeBundle() {
+			compress(resolve(__dirname, 'dist', 'assets'))
+		},
+	}
+}
+
 export default defineConfig({
+	plugins: [precompress()],
 	build: {
 		rollupOptions: {
 			input: {
 				main: resolve(__dirname, 'index.html'),
 				wakeup: resolve(__dirname, 'wakeup.html'),
 				error: resolve(__dirname, 'error.html'),
+				preview: resolve(__dirname, 'preview.html'),
+				confirm: resolve(__dirname, 'confirm.html'),
+				quiet: resolve(__dirname, 'quiet.html'),
+				denied: resolve(__dirname, 'denied.html'),
 			},
 		},
 	},
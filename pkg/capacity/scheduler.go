@@ -0,0 +1,267 @@
+package capacity
+
+import (
+	"context"
+	"log/slog"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/logger"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// wakeupGracePeriod is the time after triggering a deployment in which it still counts as running although O-Neko
+// reports it as not deployed, because the cached project is only updated once O-Neko has picked the deployment up.
+const wakeupGracePeriod = 5 * time.Minute
+
+// Deployer is the part of the O-Neko service the scheduler needs to start deployments and to check whether they are
+// still running.
+type Deployer interface {
+	GetProjectAndVersionByIds(projectUuid, versionUuid string) (*oneko.Project, *oneko.ProjectVersion, error)
+	TriggerDeployment(projectId, versionId string, ctx context.Context) error
+}
+
+// Scheduler keeps track of the deployments catnip woke up and makes sure no more of them run at the same time than
+// configured. Wake-ups exceeding the limits are queued and triggered in order as soon as running deployments are
+// stopped in O-Neko.
+type Scheduler struct {
+	log           *slog.Logger
+	configuration config.CapacityConfig
+	deployer      Deployer
+	ctx           context.Context
+	running       map[string]*wokenVersion
+	queue         []*queuedWakeup
+	lock          sync.Mutex
+	queueWaitTime prometheus.Histogram
+	clock         utils.Clock
+}
+
+type wokenVersion struct {
+	project oneko.Project
+	wokenAt time.Time
+	// seenDeployed is set once O-Neko reported the version as deployed, from then on it is stopped as soon as O-Neko
+	// reports it as not deployed
+	seenDeployed bool
+}
+
+type queuedWakeup struct {
+	project    oneko.Project
+	version    oneko.ProjectVersion
+	enqueuedAt time.Time
+}
+
+func New(ctx context.Context, capacityConfig config.CapacityConfig, deployer Deployer) *Scheduler {
+	s := newSchedulerWithClock(ctx, capacityConfig, deployer, utils.NewClock())
+	s.registerMetrics()
+	go s.run()
+	return s
+}
+
+func newSchedulerWithClock(ctx context.Context, capacityConfig config.CapacityConfig, deployer Deployer, clock utils.Clock) *Scheduler {
+	return &Scheduler{
+		log:           logger.New("capacity"),
+		configuration: capacityConfig,
+		deployer:      deployer,
+		ctx:           ctx,
+		running:       make(map[string]*wokenVersion),
+		queueWaitTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "oneko_catnip_wakeup_queue_wait_seconds",
+			Help:    "The time wake-ups spent in the queue until their deployment was triggered.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+		}),
+		clock: clock,
+	}
+}
+
+func (s *Scheduler) registerMetrics() {
+	prometheus.MustRegister(
+		s.queueWaitTime,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "oneko_catnip_wakeup_queue_depth",
+			Help: "The number of wake-ups waiting for running deployments to be stopped.",
+		}, func() float64 {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			return float64(len(s.queue))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "oneko_catnip_woken_deployments",
+			Help: "The number of deployments woken up by catnip which are still running.",
+		}, func() float64 {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			return float64(len(s.running))
+		}),
+	)
+}
+
+// Wake triggers the deployment of the version if the limits allow it and queues it otherwise. It returns the
+// position of the version in the queue, which is zero if the deployment has been triggered.
+func (s *Scheduler) Wake(ctx context.Context, project *oneko.Project, version *oneko.ProjectVersion) (int, error) {
+	s.lock.Lock()
+	if position := s.positionOf(version.Uuid); position > 0 {
+		s.lock.Unlock()
+		return position, nil
+	}
+	_, alreadyWoken := s.running[version.Uuid]
+	if !alreadyWoken {
+		if !s.fits(project) {
+			s.queue = append(s.queue, &queuedWakeup{
+				project:    *project,
+				version:    *version,
+				enqueuedAt: time.Now(),
+			})
+			position := len(s.queue)
+			s.lock.Unlock()
+			s.log.Info("queued wake-up", slog.String("project", project.Name), slog.String("version", version.Name), slog.Int("position", position))
+			return position, nil
+		}
+		s.running[version.Uuid] = &wokenVersion{project: *project, wokenAt: s.clock.Now()}
+	}
+	s.lock.Unlock()
+
+	if err := s.deployer.TriggerDeployment(project.Uuid, version.Uuid, ctx); err != nil {
+		if !alreadyWoken {
+			s.release(version.Uuid)
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+// QueuePosition returns the position of the version in the queue starting at one, or zero if it is not queued.
+func (s *Scheduler) QueuePosition(versionUuid string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.positionOf(versionUuid)
+}
+
+func (s *Scheduler) positionOf(versionUuid string) int {
+	for i, queued := range s.queue {
+		if queued.version.Uuid == versionUuid {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (s *Scheduler) fits(project *oneko.Project) bool {
+	if s.configuration.MaxRunning > 0 && len(s.running) >= s.configuration.MaxRunning {
+		return false
+	}
+	maxRunningOfProject := s.maxRunningOf(project)
+	if maxRunningOfProject == 0 {
+		return true
+	}
+	runningOfProject := 0
+	for _, woken := range s.running {
+		if woken.project.Uuid == project.Uuid {
+			runningOfProject++
+		}
+	}
+	return runningOfProject < maxRunningOfProject
+}
+
+func (s *Scheduler) maxRunningOf(project *oneko.Project) int {
+	for _, projectConfig := range s.configuration.Projects {
+		if project.MatchesAny(projectConfig.Projects) {
+			return projectConfig.MaxRunning
+		}
+	}
+	return s.configuration.MaxRunningPerProject
+}
+
+func (s *Scheduler) release(versionUuid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.running, versionUuid)
+}
+
+func (s *Scheduler) run() {
+	ticker := time.NewTicker(s.configuration.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.refresh()
+			s.processQueue()
+		}
+	}
+}
+
+// refresh frees the slots of woken deployments which are no longer deployed according to O-Neko. Deployments which
+// have not been reported as deployed yet keep their slot during the wakeupGracePeriod. The slots of versions which
+// have been deleted in O-Neko are freed as well.
+func (s *Scheduler) refresh() {
+	s.lock.Lock()
+	woken := make(map[string]wokenVersion, len(s.running))
+	for versionUuid, version := range s.running {
+		woken[versionUuid] = *version
+	}
+	s.lock.Unlock()
+
+	now := s.clock.Now()
+	for versionUuid, woken := range woken {
+		_, version, err := s.deployer.GetProjectAndVersionByIds(woken.project.Uuid, versionUuid)
+		if err != nil {
+			s.log.Info("woken deployment does not exist anymore", slog.String("versionId", versionUuid), slog.Any("error", err))
+			s.release(versionUuid)
+			continue
+		}
+		if version.IsDeployed() {
+			s.markDeployed(versionUuid)
+			continue
+		}
+		if !woken.seenDeployed && now.Sub(woken.wokenAt) < wakeupGracePeriod {
+			continue
+		}
+		s.log.Debug("woken deployment has been stopped", slog.String("version", version.Name))
+		s.release(versionUuid)
+	}
+}
+
+func (s *Scheduler) markDeployed(versionUuid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if woken, ok := s.running[versionUuid]; ok {
+		woken.seenDeployed = true
+	}
+}
+
+// processQueue triggers the queued wake-ups fitting into the free slots in the order they were queued. Wake-ups of
+// versions which have been deployed in the meantime are dropped.
+func (s *Scheduler) processQueue() {
+	s.lock.Lock()
+	var due []*queuedWakeup
+	remaining := s.queue[:0]
+	for _, queued := range s.queue {
+		if s.fits(&queued.project) {
+			s.running[queued.version.Uuid] = &wokenVersion{project: queued.project, wokenAt: s.clock.Now()}
+			due = append(due, queued)
+		} else {
+			remaining = append(remaining, queued)
+		}
+	}
+	s.queue = remaining
+	s.lock.Unlock()
+
+	for _, queued := range due {
+		s.queueWaitTime.Observe(time.Since(queued.enqueuedAt).Seconds())
+		_, version, err := s.deployer.GetProjectAndVersionByIds(queued.project.Uuid, queued.version.Uuid)
+		if err == nil && version.IsDeployed() {
+			s.log.Debug("queued version has been deployed in the meantime", slog.String("version", queued.version.Name))
+			s.release(queued.version.Uuid)
+			continue
+		}
+		s.log.Info("triggering queued wake-up", slog.String("project", queued.project.Name), slog.String("version", queued.version.Name))
+		if err := s.deployer.TriggerDeployment(queued.project.Uuid, queued.version.Uuid, s.ctx); err != nil {
+			s.log.Error("failed to trigger queued wake-up", slog.String("project", queued.project.Name), slog.String("version", queued.version.Name), slog.Any("error", err))
+			s.release(queued.version.Uuid)
+		}
+	}
+}
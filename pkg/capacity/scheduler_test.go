@@ -0,0 +1,215 @@
+package capacity
+
+import (
+	"context"
+	"fmt"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	config.OverrideConfiguration(&config.Config{
+		ONeko: config.ONekoConfig{
+			Mode: "production",
+			Logging: config.LoggingConfig{
+				Level: "error",
+			},
+		},
+	})
+	os.Exit(m.Run())
+}
+
+// fakeDeployer deploys versions immediately. Versions are stopped with stop.
+type fakeDeployer struct {
+	projects  map[string]*oneko.Project
+	triggered []string
+	lock      sync.Mutex
+}
+
+func newFakeDeployer(projects ...*oneko.Project) *fakeDeployer {
+	d := &fakeDeployer{projects: make(map[string]*oneko.Project)}
+	for _, project := range projects {
+		d.projects[project.Uuid] = project
+	}
+	return d
+}
+
+func (d *fakeDeployer) GetProjectAndVersionByIds(projectUuid, versionUuid string) (*oneko.Project, *oneko.ProjectVersion, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	project, ok := d.projects[projectUuid]
+	if !ok {
+		return nil, nil, fmt.Errorf("no project found with id %s", projectUuid)
+	}
+	version := project.GetProjectVersionMatchingUuid(versionUuid)
+	if version == nil {
+		return nil, nil, fmt.Errorf("did not find version with id %s", versionUuid)
+	}
+	return project, version, nil
+}
+
+func (d *fakeDeployer) TriggerDeployment(projectId, versionId string, ctx context.Context) error {
+	d.setDesiredState(projectId, versionId, oneko.Deployed)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.triggered = append(d.triggered, versionId)
+	return nil
+}
+
+func (d *fakeDeployer) stop(projectId, versionId string) {
+	d.setDesiredState(projectId, versionId, oneko.NotDeployed)
+}
+
+func (d *fakeDeployer) setDesiredState(projectId, versionId string, state oneko.DesiredState) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	versions := d.projects[projectId].Versions
+	for i := range versions {
+		if versions[i].Uuid == versionId {
+			versions[i].DesiredState = state
+		}
+	}
+}
+
+func newProject(name string, versions ...string) *oneko.Project {
+	project := &oneko.Project{Uuid: name, Name: name}
+	for _, version := range versions {
+		project.Versions = append(project.Versions, oneko.ProjectVersion{
+			Uuid:         name + "-" + version,
+			Name:         version,
+			DesiredState: oneko.NotDeployed,
+		})
+	}
+	return project
+}
+
+func newTestScheduler(capacityConfig config.CapacityConfig, deployer Deployer) (*Scheduler, *utils.TimeMachine) {
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	return newSchedulerWithClock(context.Background(), capacityConfig, deployer, clock), clock
+}
+
+func wake(t *testing.T, s *Scheduler, project *oneko.Project, versionName string) int {
+	version := project.GetProjectVersionMatchingName(versionName)
+	position, err := s.Wake(context.Background(), project, version)
+	assert.NoError(t, err)
+	return position
+}
+
+func Test_Wake_QueuesWakeupsOverTheGlobalLimit(t *testing.T) {
+	shop := newProject("shop", "a", "b", "c")
+	deployer := newFakeDeployer(shop)
+	s, _ := newTestScheduler(config.CapacityConfig{MaxRunning: 1}, deployer)
+
+	assert.Equal(t, 0, wake(t, s, shop, "a"))
+	assert.Equal(t, 1, wake(t, s, shop, "b"))
+	assert.Equal(t, 2, wake(t, s, shop, "c"))
+	// waking up a queued version again keeps its position
+	assert.Equal(t, 1, wake(t, s, shop, "b"))
+	assert.Equal(t, []string{"shop-a"}, deployer.triggered)
+
+	// nothing changes as long as the woken deployment is running
+	s.refresh()
+	s.processQueue()
+	assert.Equal(t, []string{"shop-a"}, deployer.triggered)
+
+	deployer.stop("shop", "shop-a")
+	s.refresh()
+	s.processQueue()
+	assert.Equal(t, []string{"shop-a", "shop-b"}, deployer.triggered)
+	assert.Equal(t, 0, s.QueuePosition("shop-b"))
+	assert.Equal(t, 1, s.QueuePosition("shop-c"))
+}
+
+func Test_Wake_LimitsRunningDeploymentsPerProject(t *testing.T) {
+	shop := newProject("shop", "a", "b")
+	database := newProject("big-database", "a", "b")
+	wiki := newProject("wiki", "a", "b")
+	deployer := newFakeDeployer(shop, database, wiki)
+	s, clock := newTestScheduler(config.CapacityConfig{
+		MaxRunningPerProject: 1,
+		Projects: []config.ProjectCapacityConfig{
+			{Projects: []string{"wiki"}, MaxRunning: 0},
+		},
+	}, deployer)
+
+	assert.Equal(t, 0, wake(t, s, database, "a"))
+	assert.Equal(t, 1, wake(t, s, database, "b"))
+	assert.Equal(t, 0, wake(t, s, shop, "a"))
+	assert.Equal(t, 2, wake(t, s, shop, "b"))
+	assert.Equal(t, 0, wake(t, s, wiki, "a"))
+	assert.Equal(t, 0, wake(t, s, wiki, "b"))
+
+	// queued wake-ups of other projects are not blocked by the first one in the queue
+	deployer.stop("shop", "shop-a")
+	clock.TimeTravel(wakeupGracePeriod)
+	s.refresh()
+	s.processQueue()
+	assert.Equal(t, 1, s.QueuePosition("big-database-b"))
+	assert.Equal(t, 0, s.QueuePosition("shop-b"))
+	assert.Contains(t, deployer.triggered, "shop-b")
+}
+
+func Test_ProcessQueue_DropsVersionsDeployedInTheMeantime(t *testing.T) {
+	shop := newProject("shop", "a", "b")
+	deployer := newFakeDeployer(shop)
+	s, clock := newTestScheduler(config.CapacityConfig{MaxRunning: 1}, deployer)
+
+	assert.Equal(t, 0, wake(t, s, shop, "a"))
+	assert.Equal(t, 1, wake(t, s, shop, "b"))
+	deployer.setDesiredState("shop", "shop-b", oneko.Deployed)
+	deployer.stop("shop", "shop-a")
+	clock.TimeTravel(wakeupGracePeriod)
+	s.refresh()
+	s.processQueue()
+
+	assert.Equal(t, []string{"shop-a"}, deployer.triggered)
+	assert.Equal(t, 0, s.QueuePosition("shop-b"))
+	assert.Empty(t, s.running)
+}
+
+func Test_Refresh_KeepsTheSlotsOfDeploymentsNotPickedUpByONekoYet(t *testing.T) {
+	shop := newProject("shop", "a", "b")
+	deployer := newFakeDeployer(shop)
+	s, clock := newTestScheduler(config.CapacityConfig{MaxRunning: 1}, deployer)
+
+	assert.Equal(t, 0, wake(t, s, shop, "a"))
+	assert.Equal(t, 1, wake(t, s, shop, "b"))
+	// the cached project still reports the triggered version as not deployed
+	deployer.stop("shop", "shop-a")
+	clock.TimeTravel(wakeupGracePeriod - time.Second)
+	s.refresh()
+	s.processQueue()
+	assert.Equal(t, []string{"shop-a"}, deployer.triggered)
+	assert.Equal(t, 1, s.QueuePosition("shop-b"))
+
+	clock.TimeTravel(time.Second)
+	s.refresh()
+	s.processQueue()
+	assert.Equal(t, []string{"shop-a", "shop-b"}, deployer.triggered)
+	assert.Equal(t, 0, s.QueuePosition("shop-b"))
+}
+
+func Test_Refresh_FreesTheSlotsOfDeletedVersions(t *testing.T) {
+	shop := newProject("shop", "a")
+	wiki := newProject("wiki", "a")
+	deployer := newFakeDeployer(shop, wiki)
+	s, _ := newTestScheduler(config.CapacityConfig{MaxRunning: 1}, deployer)
+
+	assert.Equal(t, 0, wake(t, s, shop, "a"))
+	assert.Equal(t, 1, wake(t, s, wiki, "a"))
+	deployer.lock.Lock()
+	delete(deployer.projects, "shop")
+	deployer.lock.Unlock()
+	s.refresh()
+	s.processQueue()
+
+	assert.Equal(t, []string{"shop-a", "wiki-a"}, deployer.triggered)
+	assert.Equal(t, 0, s.QueuePosition("wiki-a"))
+}
@@ -2,10 +2,12 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-playground/mold/v4"
 	"github.com/go-playground/mold/v4/modifiers"
 	"github.com/go-playground/validator/v10"
+	"path"
 	"regexp"
 	"strings"
 	"time"
@@ -88,10 +90,40 @@ func validateConfig(c Config) error {
 		return err
 	}
 
+	err = validate.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+		_, err := regexp.Compile(fl.Field().String())
+		return err == nil
+	}, false)
+
+	if err != nil {
+		return err
+	}
+
+	err = validate.RegisterValidation("pattern", func(fl validator.FieldLevel) bool {
+		return IsValidPattern(fl.Field().String())
+	}, false)
+
+	if err != nil {
+		return err
+	}
+
+	err = validate.RegisterValidation("weekday", func(fl validator.FieldLevel) bool {
+		_, ok := ParseWeekday(fl.Field().String())
+		return ok
+	}, false)
+
+	if err != nil {
+		return err
+	}
+
 	if err := validate.Struct(c); err != nil {
 		return err
 	}
 
+	if c.ONeko.Idle.Enabled && len(c.ONeko.Idle.ActivitySource.Url) == 0 {
+		return errors.New("idle.enabled requires an idle.activitySource, deployments in use would be stopped otherwise")
+	}
+
 	return nil
 }
 
@@ -100,11 +132,25 @@ type Config struct {
 }
 
 type ONekoConfig struct {
-	Api       ApiConfig     `yaml:"api" validate:"required"`
-	Mode      Mode          `yaml:"mode" validate:"required,oneof='development' 'production'"`
-	Server    ServerConfig  `yaml:"server" validate:"required"`
-	CatnipUrl string        `yaml:"catnipUrl" validate:"required,urlWithOptionalPort" mod:"trim,lcase,urlWithoutProtocol"`
-	Logging   LoggingConfig `yaml:"logging" validate:"required"`
+	Api          ApiConfig          `yaml:"api" validate:"required"`
+	Mode         Mode               `yaml:"mode" validate:"required,oneof='development' 'production'"`
+	Server       ServerConfig       `yaml:"server" validate:"required"`
+	CatnipUrl    string             `yaml:"catnipUrl" validate:"required,urlWithOptionalPort" mod:"trim,lcase,urlWithoutProtocol"`
+	Logging      LoggingConfig      `yaml:"logging" validate:"required"`
+	Replay       ReplayConfig       `yaml:"replay"`
+	Proxy        ProxyConfig        `yaml:"proxy"`
+	Routing      RoutingConfig      `yaml:"routing"`
+	Wait         WaitConfig         `yaml:"wait"`
+	Bots         BotsConfig         `yaml:"bots"`
+	Confirmation ConfirmationConfig `yaml:"confirmation"`
+	Policy       PolicyConfig       `yaml:"policy"`
+	Capacity     CapacityConfig     `yaml:"capacity"`
+	Idle         IdleConfig         `yaml:"idle"`
+	Groups       []GroupConfig      `yaml:"groups" validate:"dive"`
+	Rules        []RuleConfig       `yaml:"rules" validate:"dive"`
+	Theme        ThemeConfig        `yaml:"theme"`
+	I18n         I18nConfig         `yaml:"i18n"`
+	History      HistoryConfig      `yaml:"history"`
 }
 
 type LoggingConfig struct {
@@ -115,6 +161,17 @@ type ApiConfig struct {
 	BaseUrl              string        `yaml:"baseUrl" validate:"required,uri"`
 	Auth                 AuthConfig    `yaml:"auth" validate:"required"`
 	ApiCallCacheDuration time.Duration `yaml:"apiCallCacheDuration" validate:"required,min=15s,max=10m"`
+	// DeployGracePeriod is the time after triggering a deployment in which further triggers of the same version are
+	// ignored, zero disables it
+	DeployGracePeriod time.Duration `yaml:"deployGracePeriod" validate:"min=0,max=10m"`
+	// IndexRefreshInterval is the time between two reloads of all projects in the background
+	IndexRefreshInterval time.Duration `yaml:"indexRefreshInterval" validate:"required,min=1s,max=1h"`
+	// IndexFile persists the projects loaded from O-Neko across restarts, empty disables it
+	IndexFile string `yaml:"indexFile"`
+	// UnknownUrlCacheDuration is the time URLs which do not belong to any project are remembered as unknown
+	UnknownUrlCacheDuration time.Duration `yaml:"unknownUrlCacheDuration" validate:"min=0,max=1h"`
+	// MinIndexRebuildInterval is the minimum time between two reloads of all projects requested by unknown URLs
+	MinIndexRebuildInterval time.Duration `yaml:"minIndexRebuildInterval" validate:"min=0,max=10m"`
 }
 
 type AuthConfig struct {
@@ -142,4 +199,192 @@ const (
 type ServerConfig struct {
 	Port        int `yaml:"port" validate:"required,number"`
 	MetricsPort int `yaml:"metricsPort" validate:"required,number"`
+	// TrustedProxies are the IPs or CIDRs of proxies whose X-Forwarded-* and Forwarded headers are used
+	TrustedProxies []string `yaml:"trustedProxies" validate:"dive,cidr|ip"`
+	// FrontendDirectory serves the built frontend from disk instead of the embedded one and reloads the templates on
+	// every request, which is meant for working on the frontend
+	FrontendDirectory string `yaml:"frontendDirectory" validate:"omitempty,dir"`
+}
+
+type ReplayConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	MaxBodySize       int64         `yaml:"maxBodySize" validate:"min=0"`
+	MaxMemoryBodySize int64         `yaml:"maxMemoryBodySize" validate:"min=0,ltefield=MaxBodySize"`
+	SpoolDirectory    string        `yaml:"spoolDirectory"`
+	Timeout           time.Duration `yaml:"timeout" validate:"min=1s,max=30m"`
+}
+
+type ProxyConfig struct {
+	// Enabled switches all projects to proxy mode, Projects only the ones matching one of the patterns
+	Enabled  bool          `yaml:"enabled"`
+	Projects []string      `yaml:"projects"`
+	Timeout  time.Duration `yaml:"timeout" validate:"min=1s,max=30m"`
+}
+
+type RoutingConfig struct {
+	HostPatterns []HostPatternConfig `yaml:"hostPatterns" validate:"dive"`
+}
+
+// HostPatternConfig matches hosts either by a glob like *.preview.example.com or by a regular expression which may
+// capture the projectId, projectName, versionId or versionName in named groups.
+type HostPatternConfig struct {
+	Glob  string `yaml:"glob" validate:"required_without=Regex,excluded_with=Regex"`
+	Regex string `yaml:"regex" validate:"required_without=Glob,omitempty,regexp"`
+}
+
+// WaitConfig limits how long requests of non-browser clients are held until their deployment is ready.
+type WaitConfig struct {
+	MaxDuration time.Duration `yaml:"maxDuration" validate:"min=1s,max=30m"`
+}
+
+// BotsConfig configures the detection of link-preview bots and crawlers which must not wake up deployments. The
+// UserAgentPatterns are regular expressions complementing the built-in list of common bots.
+type BotsConfig struct {
+	Enabled           bool     `yaml:"enabled"`
+	UserAgentPatterns []string `yaml:"userAgentPatterns" validate:"dive,regexp"`
+}
+
+// ConfirmationConfig lists glob patterns matching the names or UUIDs of projects which are only started after the
+// user confirmed it on the wakeup page.
+type ConfirmationConfig struct {
+	Projects []string `yaml:"projects"`
+}
+
+// PolicyConfig restricts the times at which deployments may be woken up. Without any Windows deployments can be woken
+// up at all times. The first entry of Projects matching a project replaces the global windows for it. Requests
+// carrying the AdminToken in the oneko-catnip-admin-token header are not restricted.
+type PolicyConfig struct {
+	TimeZone   string                `yaml:"timeZone" validate:"omitempty,timezone"`
+	Windows    []WakeupWindowConfig  `yaml:"windows" validate:"dive"`
+	AdminToken string                `yaml:"adminToken"`
+	Projects   []ProjectPolicyConfig `yaml:"projects" validate:"dive"`
+}
+
+// WakeupWindowConfig allows wake-ups on the given Weekdays (all days if empty) from From until To, both given as
+// 15:04. A window whose end is not after its start ends on the following day.
+type WakeupWindowConfig struct {
+	Weekdays []string `yaml:"weekdays" validate:"dive,weekday"`
+	From     string   `yaml:"from" validate:"required,datetime=15:04"`
+	To       string   `yaml:"to" validate:"required,datetime=15:04"`
+}
+
+// ProjectPolicyConfig overrides the wake-up windows for projects whose name or UUID matches one of the glob patterns
+// in Projects. An empty TimeZone falls back to the global one, empty Windows allow wake-ups at all times.
+type ProjectPolicyConfig struct {
+	Projects []string             `yaml:"projects" validate:"required,min=1"`
+	TimeZone string               `yaml:"timeZone" validate:"omitempty,timezone"`
+	Windows  []WakeupWindowConfig `yaml:"windows" validate:"dive"`
+}
+
+// CapacityConfig limits the number of deployments woken up by catnip which run at the same time, zero meaning
+// unlimited. The first entry of Projects matching a project replaces MaxRunningPerProject for it. Whether woken up
+// deployments are still running is checked every RefreshInterval.
+type CapacityConfig struct {
+	MaxRunning           int                     `yaml:"maxRunning" validate:"min=0"`
+	MaxRunningPerProject int                     `yaml:"maxRunningPerProject" validate:"min=0"`
+	Projects             []ProjectCapacityConfig `yaml:"projects" validate:"dive"`
+	RefreshInterval      time.Duration           `yaml:"refreshInterval" validate:"min=1s,max=10m"`
+}
+
+// ProjectCapacityConfig limits the number of running deployments of projects whose name or UUID matches one of the
+// glob patterns in Projects.
+type ProjectCapacityConfig struct {
+	Projects   []string `yaml:"projects" validate:"required,min=1"`
+	MaxRunning int      `yaml:"maxRunning" validate:"min=0"`
+}
+
+// IdleConfig stops deployments woken up by catnip once they have not been used for IdlePeriod. Only projects whose
+// name or UUID matches one of the glob patterns in Projects are stopped. In DryRun mode idle deployments are only
+// logged and counted. Requests going directly to a running deployment do not pass catnip, so the ActivitySource is
+// required.
+type IdleConfig struct {
+	Enabled        bool                 `yaml:"enabled"`
+	DryRun         bool                 `yaml:"dryRun"`
+	Projects       []string             `yaml:"projects"`
+	IdlePeriod     time.Duration        `yaml:"idlePeriod" validate:"min=1m,max=24h"`
+	CheckInterval  time.Duration        `yaml:"checkInterval" validate:"min=1s,max=1h"`
+	ActivitySource ActivitySourceConfig `yaml:"activitySource"`
+}
+
+// ActivitySourceConfig queries the Url of a Prometheus compatible HTTP API, e.g. http://prometheus:9090/api/v1/query,
+// whether a deployment has been used during the idle period. Query is a Go template rendered for every deployment,
+// results above Threshold count as activity.
+type ActivitySourceConfig struct {
+	Url       string            `yaml:"url" validate:"omitempty,url"`
+	Query     string            `yaml:"query" validate:"required_with=Url"`
+	Headers   map[string]string `yaml:"headers"`
+	Threshold float64           `yaml:"threshold"`
+	Timeout   time.Duration     `yaml:"timeout" validate:"min=1s,max=1m"`
+}
+
+// GroupConfig links the versions with the same name across all projects matching one of the glob patterns, e.g. a
+// frontend and the backend it needs. Waking up one member wakes up the whole group. Members of OptionalProjects are
+// woken up as well, but the group is ready without them.
+type GroupConfig struct {
+	Name             string   `yaml:"name" validate:"required"`
+	Projects         []string `yaml:"projects" validate:"required,min=1"`
+	OptionalProjects []string `yaml:"optionalProjects"`
+}
+
+// RuleConfig decides how versions may be woken up. The first rule matching both the project and the version applies,
+// versions without a matching rule are allowed. Projects match the name or UUID of the project, Versions the name of
+// the version, empty lists match everything. Patterns enclosed in slashes like /^release-.*/ are regular expressions,
+// all others glob patterns. Denied versions show the Reason and whom to ask, the Contact. Versions of redirect rules
+// are not woken up either, their users are sent to RedirectTo instead.
+type RuleConfig struct {
+	Projects   []string `yaml:"projects" validate:"dive,pattern"`
+	Versions   []string `yaml:"versions" validate:"dive,pattern"`
+	Action     string   `yaml:"action" validate:"required,oneof=allow deny confirm redirect"`
+	RedirectTo string   `yaml:"redirectTo" validate:"required_if=Action redirect,omitempty,url"`
+	Reason     string   `yaml:"reason"`
+	Contact    string   `yaml:"contact"`
+}
+
+// ThemeConfig points to a directory overriding the built-in frontend file by file. Templates are read from the
+// directory itself, e.g. wakeup.html, assets like logos from its assets subdirectory and message catalogs from its
+// locales subdirectory.
+type ThemeConfig struct {
+	Directory string `yaml:"directory" validate:"omitempty,dir"`
+}
+
+// HistoryConfig keeps the durations of the last Samples wake-ups of every version, which are used to estimate when a
+// woken deployment will be ready. File persists them across restarts, empty keeps them in memory only. Deployments not
+// ready within MaxWait are not recorded.
+type HistoryConfig struct {
+	File    string        `yaml:"file"`
+	Samples int           `yaml:"samples" validate:"min=1,max=1000"`
+	MaxWait time.Duration `yaml:"maxWait" validate:"min=1m,max=24h"`
+}
+
+type I18nConfig struct {
+	// DefaultLanguage is used if the browser accepts none of the languages with a catalog
+	DefaultLanguage string `yaml:"defaultLanguage" validate:"omitempty,bcp47_language_tag"`
+}
+
+// IsRegexpPattern reports whether the pattern of a rule is a regular expression rather than a glob pattern.
+func IsRegexpPattern(pattern string) bool {
+	return len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+func IsValidPattern(pattern string) bool {
+	if IsRegexpPattern(pattern) {
+		_, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		return err == nil
+	}
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// ParseWeekday parses English weekday names like "Monday" or "mon" regardless of their case.
+func ParseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) < 3 {
+		return time.Sunday, false
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.HasPrefix(strings.ToLower(day.String()), name) {
+			return day, true
+		}
+	}
+	return time.Sunday, false
 }
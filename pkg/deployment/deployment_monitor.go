@@ -1,36 +1,61 @@
 package deployment
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-resty/resty/v2"
 	"github.com/jellydator/ttlcache/v3"
 	"log/slog"
 	"o-neko-catnip/pkg/logger"
+	"sync"
 	"time"
 )
 
 type DeploymentMonitor struct {
-	client      *resty.Client
-	statusCache *ttlcache.Cache[string, *StatusResponse]
-	log         *slog.Logger
+	client       *resty.Client
+	statusCache  *ttlcache.Cache[string, *StatusResponse]
+	pollInterval time.Duration
+	watchers     map[string]*statusWatcher
+	watchersLock sync.Mutex
+	log          *slog.Logger
+	// readyListeners are called with the URLs of deployments found to be ready
+	readyListeners []func(url string)
 }
 
 func New() *DeploymentMonitor {
-	client := resty.New()
-	cache := ttlcache.New[string, *StatusResponse](
-		ttlcache.WithTTL[string, *StatusResponse](5*time.Second),
+	return NewWithIntervals(5*time.Second, 1*time.Second)
+}
+
+// NewWithIntervals creates a monitor which remembers the status of a deployment for statusCacheDuration and checks
+// it every pollInterval while waiting for the deployment, e.g. to check more often in tests.
+func NewWithIntervals(statusCacheDuration, pollInterval time.Duration) *DeploymentMonitor {
+	d := &DeploymentMonitor{
+		client:       resty.New(),
+		pollInterval: pollInterval,
+		watchers:     make(map[string]*statusWatcher),
+		log:          logger.New("deployment-monitor"),
+	}
+	d.statusCache = ttlcache.New[string, *StatusResponse](
+		ttlcache.WithTTL[string, *StatusResponse](statusCacheDuration),
 		ttlcache.WithDisableTouchOnHit[string, *StatusResponse](),
 		ttlcache.WithLoader[string, *StatusResponse](ttlcache.LoaderFunc[string, *StatusResponse](func(c *ttlcache.Cache[string, *StatusResponse], deploymentUrl string) *ttlcache.Item[string, *StatusResponse] {
-			status := calculateDeploymentStatus(client, deploymentUrl)
+			status := calculateDeploymentStatus(d.client, deploymentUrl)
 			item := c.Set(deploymentUrl, status, ttlcache.DefaultTTL)
+			if status.DeploymentStatus == Ready {
+				for _, listener := range d.readyListeners {
+					listener(deploymentUrl)
+				}
+			}
 			return item
 		})),
 	)
-	return &DeploymentMonitor{
-		client:      client,
-		statusCache: cache,
-		log:         logger.New("deployment-monitor"),
-	}
+	return d
+}
+
+// OnReady registers a function called whenever the status of a deployment has been checked and it is ready. It must
+// be called before any status is checked.
+func (d *DeploymentMonitor) OnReady(listener func(url string)) {
+	d.readyListeners = append(d.readyListeners, listener)
 }
 
 func (d *DeploymentMonitor) DeploymentStatus(url string) (*StatusResponse, error) {
@@ -41,6 +66,27 @@ func (d *DeploymentMonitor) DeploymentStatus(url string) (*StatusResponse, error
 	return nil, fmt.Errorf("could not find item in cache and loader did not load item for deployment url %s", url)
 }
 
+// WaitUntilReady polls the status of the deployment until it is ready, the context is done or the timeout expired.
+func (d *DeploymentMonitor) WaitUntilReady(ctx context.Context, url string, timeout time.Duration) (*StatusResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := d.DeploymentStatus(url)
+		if err == nil && status.DeploymentStatus == Ready {
+			return status, nil
+		}
+		select {
+		case <-ctx.Done():
+			return status, fmt.Errorf("deployment %s did not become ready: %w", url, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
 func calculateDeploymentStatus(client *resty.Client, url string) *StatusResponse {
 	response, err := client.R().Head(url)
 
@@ -0,0 +1,54 @@
+package deployment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WaitUntilReady_ReturnsOnceTheDeploymentIsReady(t *testing.T) {
+	var ready atomic.Bool
+	deployment := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ready.Load() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer deployment.Close()
+	monitor := NewWithIntervals(5*time.Millisecond, 5*time.Millisecond)
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		ready.Store(true)
+	})
+	status, err := monitor.WaitUntilReady(context.Background(), deployment.URL, 5*time.Second)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Ready, status.DeploymentStatus)
+}
+
+func Test_WaitUntilReady_GivesUpAfterTheTimeout(t *testing.T) {
+	deployment := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer deployment.Close()
+	monitor := NewWithIntervals(5*time.Millisecond, 5*time.Millisecond)
+
+	startedAt := time.Now()
+	status, err := monitor.WaitUntilReady(context.Background(), deployment.URL, 50*time.Millisecond)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, Pending, status.DeploymentStatus)
+	assert.Less(t, time.Since(startedAt), 5*time.Second)
+
+	// a cancelled request stops waiting as well
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = monitor.WaitUntilReady(ctx, deployment.URL, 5*time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+}
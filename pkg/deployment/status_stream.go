@@ -0,0 +1,90 @@
+package deployment
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// statusWatcher polls the status of a single deployment URL and fans status changes out to all subscribers.
+type statusWatcher struct {
+	subscribers map[chan *StatusResponse]struct{}
+	last        *StatusResponse
+	cancel      context.CancelFunc
+}
+
+// Subscribe returns a channel receiving the current status of the deployment and every change afterwards. Slow
+// subscribers only receive the latest status. All subscribers of a URL share one goroutine polling the status, which
+// stops once the last subscriber called the returned unsubscribe function.
+func (d *DeploymentMonitor) Subscribe(url string) (<-chan *StatusResponse, func()) {
+	updates := make(chan *StatusResponse, 1)
+
+	d.watchersLock.Lock()
+	watcher, exists := d.watchers[url]
+	if !exists {
+		ctx, cancel := context.WithCancel(context.Background())
+		watcher = &statusWatcher{
+			subscribers: make(map[chan *StatusResponse]struct{}),
+			cancel:      cancel,
+		}
+		d.watchers[url] = watcher
+		go d.watch(ctx, url, watcher)
+		d.log.Debug("started watching deployment status", slog.String("url", url))
+	}
+	watcher.subscribers[updates] = struct{}{}
+	if watcher.last != nil {
+		publish(updates, watcher.last)
+	}
+	d.watchersLock.Unlock()
+
+	unsubscribe := func() {
+		d.watchersLock.Lock()
+		defer d.watchersLock.Unlock()
+		delete(watcher.subscribers, updates)
+		if len(watcher.subscribers) == 0 && d.watchers[url] == watcher {
+			watcher.cancel()
+			delete(d.watchers, url)
+			d.log.Debug("stopped watching deployment status", slog.String("url", url))
+		}
+	}
+	return updates, unsubscribe
+}
+
+func (d *DeploymentMonitor) watch(ctx context.Context, url string, watcher *statusWatcher) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := d.DeploymentStatus(url)
+		if err == nil {
+			d.watchersLock.Lock()
+			if ctx.Err() == nil && hasChanged(watcher.last, status) {
+				watcher.last = status
+				for subscriber := range watcher.subscribers {
+					publish(subscriber, status)
+				}
+			}
+			d.watchersLock.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish replaces a status the subscriber has not received yet. It must only be called while holding the
+// watchersLock, so there is no other sender.
+func publish(subscriber chan *StatusResponse, status *StatusResponse) {
+	select {
+	case <-subscriber:
+	default:
+	}
+	subscriber <- status
+}
+
+func hasChanged(last, current *StatusResponse) bool {
+	return last == nil || last.DeploymentStatus != current.DeploymentStatus || last.ErrorMessage != current.ErrorMessage
+}
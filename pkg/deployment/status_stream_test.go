@@ -0,0 +1,100 @@
+package deployment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"o-neko-catnip/pkg/config"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	config.OverrideConfiguration(&config.Config{
+		ONeko: config.ONekoConfig{
+			Mode: "production",
+			Logging: config.LoggingConfig{
+				Level: "error",
+			},
+		},
+	})
+	os.Exit(m.Run())
+}
+
+func Test_Subscribe_FansOutStatusChanges(t *testing.T) {
+	var ready atomic.Bool
+	deployment := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ready.Load() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer deployment.Close()
+
+	monitor := NewWithIntervals(5*time.Millisecond, 5*time.Millisecond)
+
+	first, unsubscribeFirst := monitor.Subscribe(deployment.URL)
+	second, unsubscribeSecond := monitor.Subscribe(deployment.URL)
+	assert.Len(t, monitor.watchers, 1)
+
+	assert.Equal(t, Pending, receiveStatus(t, first).DeploymentStatus)
+	assert.Equal(t, Pending, receiveStatus(t, second).DeploymentStatus)
+
+	ready.Store(true)
+
+	assert.Equal(t, Ready, receiveStatus(t, first).DeploymentStatus)
+	assert.Equal(t, Ready, receiveStatus(t, second).DeploymentStatus)
+
+	// late subscribers immediately receive the last known status
+	third, unsubscribeThird := monitor.Subscribe(deployment.URL)
+	assert.Equal(t, Ready, receiveStatus(t, third).DeploymentStatus)
+
+	unsubscribeFirst()
+	unsubscribeSecond()
+	assert.Len(t, monitor.watchers, 1)
+	unsubscribeThird()
+	assert.Empty(t, monitor.watchers)
+}
+
+func receiveStatus(t *testing.T, updates <-chan *StatusResponse) *StatusResponse {
+	select {
+	case status := <-updates:
+		return status
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not receive a status update")
+		return nil
+	}
+}
+
+func Test_OnReady_IsCalledWhenDeploymentsAreFoundReady(t *testing.T) {
+	var ready atomic.Bool
+	deployment := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ready.Load() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer deployment.Close()
+
+	monitor := NewWithIntervals(time.Millisecond, time.Millisecond)
+	var readyUrls []string
+	monitor.OnReady(func(url string) {
+		readyUrls = append(readyUrls, url)
+	})
+
+	_, err := monitor.DeploymentStatus(deployment.URL)
+	assert.NoError(t, err)
+	assert.Empty(t, readyUrls)
+
+	ready.Store(true)
+	time.Sleep(5 * time.Millisecond)
+	status, err := monitor.DeploymentStatus(deployment.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, Ready, status.DeploymentStatus)
+	assert.Equal(t, []string{deployment.URL}, readyUrls)
+}
@@ -0,0 +1,62 @@
+package groups
+
+import (
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+)
+
+// ProjectLister lists all projects known to O-Neko without waiting for it.
+type ProjectLister interface {
+	GetAllProjects() []*oneko.Project
+}
+
+// Groups finds the versions which are woken up together because they are only useful together.
+type Groups struct {
+	configuration []config.GroupConfig
+	projects      ProjectLister
+}
+
+// Member is a version of a group. The group is ready once all required members are ready.
+type Member struct {
+	Project  *oneko.Project
+	Version  *oneko.ProjectVersion
+	Required bool
+}
+
+func New(groupConfig []config.GroupConfig, projects ProjectLister) *Groups {
+	return &Groups{
+		configuration: groupConfig,
+		projects:      projects,
+	}
+}
+
+// Members returns the version itself followed by the versions with the same name in the other projects of all groups
+// its project belongs to. Versions outside any group are the only member of their group.
+func (g *Groups) Members(project *oneko.Project, version *oneko.ProjectVersion) []Member {
+	members := []Member{{Project: project, Version: version, Required: true}}
+	indexByVersion := map[string]int{version.Uuid: 0}
+
+	for _, group := range g.configuration {
+		if !project.MatchesAny(group.Projects) && !project.MatchesAny(group.OptionalProjects) {
+			continue
+		}
+		for _, other := range g.projects.GetAllProjects() {
+			required := other.MatchesAny(group.Projects)
+			if !required && !other.MatchesAny(group.OptionalProjects) {
+				continue
+			}
+			otherVersion := other.GetProjectVersionMatchingName(version.Name)
+			if otherVersion == nil {
+				continue
+			}
+			if i, ok := indexByVersion[otherVersion.Uuid]; ok {
+				// required in any group means required
+				members[i].Required = members[i].Required || required
+				continue
+			}
+			indexByVersion[otherVersion.Uuid] = len(members)
+			members = append(members, Member{Project: other, Version: otherVersion, Required: required})
+		}
+	}
+	return members
+}
@@ -0,0 +1,77 @@
+package groups
+
+import (
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticProjects []*oneko.Project
+
+func (p staticProjects) GetAllProjects() []*oneko.Project {
+	return p
+}
+
+func newProject(name string, versions ...string) *oneko.Project {
+	project := &oneko.Project{Uuid: name, Name: name}
+	for _, version := range versions {
+		project.Versions = append(project.Versions, oneko.ProjectVersion{
+			Uuid: name + "-" + version,
+			Name: version,
+		})
+	}
+	return project
+}
+
+func memberVersions(members []Member) map[string]bool {
+	versions := make(map[string]bool)
+	for _, member := range members {
+		versions[member.Version.Uuid] = member.Required
+	}
+	return versions
+}
+
+func Test_Members_LinksVersionsWithTheSameName(t *testing.T) {
+	frontend := newProject("shop-frontend", "main", "feature-a")
+	backend := newProject("shop-backend", "main", "feature-a")
+	docs := newProject("shop-docs", "main")
+	wiki := newProject("wiki", "main")
+	groups := New([]config.GroupConfig{
+		{Name: "shop", Projects: []string{"shop-frontend", "shop-backend"}, OptionalProjects: []string{"shop-docs"}},
+	}, staticProjects{backend, docs, frontend, wiki})
+
+	members := groups.Members(frontend, &frontend.Versions[0])
+	assert.Equal(t, "shop-frontend-main", members[0].Version.Uuid)
+	assert.Equal(t, map[string]bool{
+		"shop-frontend-main": true,
+		"shop-backend-main":  true,
+		"shop-docs-main":     false,
+	}, memberVersions(members))
+
+	// projects without a version of the same name are left out
+	assert.Equal(t, map[string]bool{
+		"shop-backend-feature-a":  true,
+		"shop-frontend-feature-a": true,
+	}, memberVersions(groups.Members(backend, &backend.Versions[1])))
+
+	// versions of other projects are on their own
+	assert.Equal(t, map[string]bool{"wiki-main": true}, memberVersions(groups.Members(wiki, &wiki.Versions[0])))
+}
+
+func Test_Members_MergesOverlappingGroups(t *testing.T) {
+	frontend := newProject("shop-frontend", "main")
+	backend := newProject("shop-backend", "main")
+	auth := newProject("auth", "main")
+	groups := New([]config.GroupConfig{
+		{Name: "shop", Projects: []string{"shop-*"}, OptionalProjects: []string{"auth"}},
+		{Name: "login", Projects: []string{"shop-frontend", "auth"}},
+	}, staticProjects{auth, backend, frontend})
+
+	assert.Equal(t, map[string]bool{
+		"shop-frontend-main": true,
+		"shop-backend-main":  true,
+		"auth-main":          true,
+	}, memberVersions(groups.Members(frontend, &frontend.Versions[0])))
+}
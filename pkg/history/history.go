@@ -0,0 +1,293 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/logger"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/oneko/service"
+	"o-neko-catnip/pkg/utils"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Deployer is the part of the O-Neko service the history needs to trigger deployments.
+type Deployer interface {
+	GetProjectAndVersionByIds(projectUuid, versionUuid string) (*oneko.Project, *oneko.ProjectVersion, error)
+	TriggerDeployment(projectId, versionId string, ctx context.Context) error
+}
+
+// History measures how long woken deployments take until they are ready and estimates from the last wake-ups when a
+// deployment will be ready. It wraps the Deployer, so every deployment triggered through it is measured. The
+// deployment is ready once the DeploymentMonitor first finds it ready, see Ready.
+type History struct {
+	log             *slog.Logger
+	configuration   config.HistoryConfig
+	deployer        Deployer
+	clock           utils.Clock
+	pending         map[string]*pendingWakeup
+	versions        map[string]*versionHistory
+	lock            sync.Mutex
+	persistRequests chan struct{}
+	wakeupToReady   *prometheus.HistogramVec
+}
+
+type pendingWakeup struct {
+	projectUuid string
+	projectName string
+	versionUuid string
+	urlPrefixes []string
+	triggeredAt time.Time
+}
+
+// versionHistory contains the durations of the last wake-ups of a version, the latest last.
+type versionHistory struct {
+	ProjectUuid string          `json:"projectUuid"`
+	Durations   []time.Duration `json:"durations"`
+}
+
+// historyFile is the content of the file the history is persisted in.
+type historyFile struct {
+	Versions map[string]*versionHistory `json:"versions"`
+}
+
+func New(ctx context.Context, historyConfig config.HistoryConfig, deployer Deployer) *History {
+	h := newHistoryWithClock(historyConfig, deployer, utils.NewClock())
+	h.registerMetrics()
+	h.load()
+	if len(historyConfig.File) > 0 {
+		go h.runWriter(ctx)
+	}
+	return h
+}
+
+func newHistoryWithClock(historyConfig config.HistoryConfig, deployer Deployer, clock utils.Clock) *History {
+	return &History{
+		log:             logger.New("history"),
+		configuration:   historyConfig,
+		deployer:        deployer,
+		clock:           clock,
+		pending:         make(map[string]*pendingWakeup),
+		versions:        make(map[string]*versionHistory),
+		persistRequests: make(chan struct{}, 1),
+		wakeupToReady: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "oneko_catnip_wakeup_to_ready_seconds",
+			Help:    "The time from triggering the deployment of a woken version until it was found to be ready.",
+			Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+		}, []string{"project"}),
+	}
+}
+
+func (h *History) registerMetrics() {
+	prometheus.MustRegister(h.wakeupToReady)
+}
+
+func (h *History) GetProjectAndVersionByIds(projectUuid, versionUuid string) (*oneko.Project, *oneko.ProjectVersion, error) {
+	return h.deployer.GetProjectAndVersionByIds(projectUuid, versionUuid)
+}
+
+// TriggerDeployment triggers the deployment and starts measuring the time until it is ready. Triggering a version
+// again while waiting for it keeps the first time.
+func (h *History) TriggerDeployment(projectId, versionId string, ctx context.Context) error {
+	if err := h.deployer.TriggerDeployment(projectId, versionId, ctx); err != nil {
+		return err
+	}
+	project, version, err := h.deployer.GetProjectAndVersionByIds(projectId, versionId)
+	if err != nil || len(version.Urls) == 0 {
+		return nil
+	}
+
+	now := h.clock.Now()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.forgetExpiredWakeups(now)
+	if _, ok := h.pending[versionId]; ok {
+		return nil
+	}
+	prefixes := make([]string, 0, len(version.Urls))
+	for _, versionUrl := range version.Urls {
+		prefixes = append(prefixes, service.GetDeploymentUrlPrefix(versionUrl))
+	}
+	h.pending[versionId] = &pendingWakeup{
+		projectUuid: project.Uuid,
+		projectName: project.Name,
+		versionUuid: version.Uuid,
+		urlPrefixes: prefixes,
+		triggeredAt: now,
+	}
+	return nil
+}
+
+// forgetExpiredWakeups drops wake-ups which did not become ready in time, e.g. because nobody waited for them. It must
+// only be called while holding the lock.
+func (h *History) forgetExpiredWakeups(now time.Time) {
+	for versionUuid, wakeup := range h.pending {
+		if now.Sub(wakeup.triggeredAt) > h.configuration.MaxWait {
+			delete(h.pending, versionUuid)
+		}
+	}
+}
+
+// Ready records the duration of the wake-up of the version the deployment URL belongs to, if there is one.
+func (h *History) Ready(deploymentUrl string) {
+	prefix := service.GetDeploymentUrlPrefix(deploymentUrl)
+	now := h.clock.Now()
+
+	h.lock.Lock()
+	h.forgetExpiredWakeups(now)
+	var wakeup *pendingWakeup
+	for _, pending := range h.pending {
+		if pending.matches(prefix) {
+			wakeup = pending
+			break
+		}
+	}
+	if wakeup == nil {
+		h.lock.Unlock()
+		return
+	}
+	delete(h.pending, wakeup.versionUuid)
+	duration := now.Sub(wakeup.triggeredAt)
+	history, ok := h.versions[wakeup.versionUuid]
+	if !ok {
+		history = &versionHistory{ProjectUuid: wakeup.projectUuid}
+		h.versions[wakeup.versionUuid] = history
+	}
+	history.Durations = append(history.Durations, duration)
+	if excess := len(history.Durations) - h.configuration.Samples; excess > 0 {
+		history.Durations = history.Durations[excess:]
+	}
+	h.lock.Unlock()
+
+	h.log.Debug("woken deployment is ready", slog.String("project", wakeup.projectName), slog.String("versionId", wakeup.versionUuid), slog.Duration("duration", duration))
+	h.wakeupToReady.WithLabelValues(wakeup.projectName).Observe(duration.Seconds())
+	h.requestPersist()
+}
+
+// matches checks whether the URL prefix belongs to the version. Prefixes matched by host patterns lack the path.
+func (w *pendingWakeup) matches(prefix string) bool {
+	for _, versionPrefix := range w.urlPrefixes {
+		if versionPrefix == prefix || strings.HasPrefix(versionPrefix, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// EstimateReadyAt estimates when the deployment of the version will be ready, based on the median duration of its
+// last wake-ups or, if it has never been woken up, of the other versions of its project. It returns false if the
+// version is not being woken up or there is no history to base the estimate on.
+func (h *History) EstimateReadyAt(versionUuid string) (time.Time, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	wakeup, ok := h.pending[versionUuid]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	var durations []time.Duration
+	if history, ok := h.versions[versionUuid]; ok {
+		durations = history.Durations
+	} else {
+		for _, history := range h.versions {
+			if history.ProjectUuid == wakeup.projectUuid {
+				durations = append(durations, history.Durations...)
+			}
+		}
+	}
+	if len(durations) == 0 {
+		return time.Time{}, false
+	}
+	return wakeup.triggeredAt.Add(median(durations)), true
+}
+
+func median(durations []time.Duration) time.Duration {
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+func (h *History) load() {
+	if len(h.configuration.File) == 0 {
+		return
+	}
+	content, err := os.ReadFile(h.configuration.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		h.log.Warn("failed to read the wake-up history", slog.String("file", h.configuration.File), slog.Any("error", err))
+		return
+	}
+	var file historyFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		h.log.Warn("invalid wake-up history", slog.String("file", h.configuration.File), slog.Any("error", err))
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for versionUuid, history := range file.Versions {
+		if history == nil || len(history.Durations) == 0 {
+			continue
+		}
+		if excess := len(history.Durations) - h.configuration.Samples; excess > 0 {
+			history.Durations = history.Durations[excess:]
+		}
+		h.versions[versionUuid] = history
+	}
+}
+
+// requestPersist asks the writer to persist the history soon. Ready is called while requests wait for the deployment,
+// so it never waits for the disk. Requests arriving while a write is pending are coalesced into it.
+func (h *History) requestPersist() {
+	select {
+	case h.persistRequests <- struct{}{}:
+	default:
+	}
+}
+
+// runWriter persists the history whenever it has been requested and once more on shutdown, if a request is pending.
+func (h *History) runWriter(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			select {
+			case <-h.persistRequests:
+				h.persist()
+			default:
+			}
+			return
+		case <-h.persistRequests:
+			h.persist()
+		}
+	}
+}
+
+// persist writes the history to the file. It is only called by the writer.
+func (h *History) persist() {
+	if len(h.configuration.File) == 0 {
+		return
+	}
+	h.lock.Lock()
+	content, err := json.Marshal(historyFile{Versions: h.versions})
+	h.lock.Unlock()
+	if err != nil {
+		h.log.Warn("failed to encode the wake-up history", slog.Any("error", err))
+		return
+	}
+	if err := utils.WriteFileAtomically(h.configuration.File, content); err != nil {
+		h.log.Warn("failed to persist the wake-up history", slog.String("file", h.configuration.File), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,168 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	config.OverrideConfiguration(&config.Config{
+		ONeko: config.ONekoConfig{
+			Mode: "production",
+			Logging: config.LoggingConfig{
+				Level: "error",
+			},
+		},
+	})
+	os.Exit(m.Run())
+}
+
+// fakeDeployer triggers deployments without doing anything.
+type fakeDeployer struct {
+	projects  map[string]*oneko.Project
+	failNext  bool
+	triggered []string
+}
+
+func newFakeDeployer(projects ...*oneko.Project) *fakeDeployer {
+	d := &fakeDeployer{projects: make(map[string]*oneko.Project)}
+	for _, project := range projects {
+		d.projects[project.Uuid] = project
+	}
+	return d
+}
+
+func (d *fakeDeployer) GetProjectAndVersionByIds(projectUuid, versionUuid string) (*oneko.Project, *oneko.ProjectVersion, error) {
+	project, ok := d.projects[projectUuid]
+	if !ok {
+		return nil, nil, fmt.Errorf("no project found with id %s", projectUuid)
+	}
+	version := project.GetProjectVersionMatchingUuid(versionUuid)
+	if version == nil {
+		return nil, nil, fmt.Errorf("did not find version with id %s", versionUuid)
+	}
+	return project, version, nil
+}
+
+func (d *fakeDeployer) TriggerDeployment(projectId, versionId string, ctx context.Context) error {
+	if d.failNext {
+		d.failNext = false
+		return fmt.Errorf("O-Neko is down")
+	}
+	d.triggered = append(d.triggered, versionId)
+	return nil
+}
+
+var shop = &oneko.Project{
+	Uuid: "shop-uuid",
+	Name: "shop",
+	Versions: []oneko.ProjectVersion{
+		{Uuid: "main-uuid", Name: "main", Urls: []string{"https://main.shop.example.com/"}},
+		{Uuid: "feature-uuid", Name: "feature", Urls: []string{"feature.shop.example.com/app"}},
+		{Uuid: "hidden-uuid", Name: "hidden"},
+	},
+}
+
+func Test_History_MeasuresWakeupsAndEstimatesTheNextOnes(t *testing.T) {
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	deployer := newFakeDeployer(shop)
+	h := newHistoryWithClock(config.HistoryConfig{Samples: 3, MaxWait: 10 * time.Minute}, deployer, clock)
+
+	for _, duration := range []time.Duration{time.Minute, 4 * time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		assert.NoError(t, h.TriggerDeployment(shop.Uuid, "main-uuid", context.Background()))
+		clock.TimeTravel(duration / 2)
+		// triggering again while waiting keeps the first time
+		assert.NoError(t, h.TriggerDeployment(shop.Uuid, "main-uuid", context.Background()))
+		clock.TimeTravel(duration / 2)
+		h.Ready("http://main.shop.example.com")
+	}
+	assert.Equal(t, []time.Duration{4 * time.Minute, 2 * time.Minute, 3 * time.Minute}, h.versions["main-uuid"].Durations)
+	assert.Equal(t, 1, testutil.CollectAndCount(h.wakeupToReady, "oneko_catnip_wakeup_to_ready_seconds"))
+
+	_, ok := h.EstimateReadyAt("main-uuid")
+	assert.False(t, ok, "the version is not being woken up")
+
+	assert.NoError(t, h.TriggerDeployment(shop.Uuid, "main-uuid", context.Background()))
+	readyAt, ok := h.EstimateReadyAt("main-uuid")
+	assert.True(t, ok)
+	assert.Equal(t, clock.Now().Add(3*time.Minute), readyAt)
+
+	// versions never woken up before are estimated from the other versions of their project
+	assert.NoError(t, h.TriggerDeployment(shop.Uuid, "feature-uuid", context.Background()))
+	readyAt, ok = h.EstimateReadyAt("feature-uuid")
+	assert.True(t, ok)
+	assert.Equal(t, clock.Now().Add(3*time.Minute), readyAt)
+}
+
+func Test_History_IgnoresUnknownAndExpiredWakeups(t *testing.T) {
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	deployer := newFakeDeployer(shop)
+	h := newHistoryWithClock(config.HistoryConfig{Samples: 3, MaxWait: 10 * time.Minute}, deployer, clock)
+
+	deployer.failNext = true
+	assert.Error(t, h.TriggerDeployment(shop.Uuid, "main-uuid", context.Background()))
+	assert.Empty(t, h.pending)
+
+	// versions without URLs never become ready
+	assert.NoError(t, h.TriggerDeployment(shop.Uuid, "hidden-uuid", context.Background()))
+	assert.Empty(t, h.pending)
+
+	assert.NoError(t, h.TriggerDeployment(shop.Uuid, "feature-uuid", context.Background()))
+	h.Ready("https://main.shop.example.com")
+	h.Ready("https://feature.shop.example.com/other")
+	assert.Len(t, h.pending, 1)
+
+	clock.TimeTravel(11 * time.Minute)
+	h.Ready("https://feature.shop.example.com/app")
+	assert.Empty(t, h.pending)
+	assert.Empty(t, h.versions)
+
+	// host patterns match deployments by their host only
+	assert.NoError(t, h.TriggerDeployment(shop.Uuid, "feature-uuid", context.Background()))
+	clock.TimeTravel(time.Minute)
+	h.Ready("https://feature.shop.example.com")
+	assert.Equal(t, []time.Duration{time.Minute}, h.versions["feature-uuid"].Durations)
+}
+
+func Test_History_IsPersisted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.json")
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	historyConfig := config.HistoryConfig{File: file, Samples: 2, MaxWait: 10 * time.Minute}
+	h := newHistoryWithClock(historyConfig, newFakeDeployer(shop), clock)
+
+	assert.NoError(t, h.TriggerDeployment(shop.Uuid, "main-uuid", context.Background()))
+	clock.TimeTravel(90 * time.Second)
+	h.Ready("https://main.shop.example.com/")
+
+	// the history is written in the background, pending writes are done on shutdown at the latest
+	_, err := os.Stat(file)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		h.runWriter(ctx)
+		close(stopped)
+	}()
+	cancel()
+	<-stopped
+
+	restarted := newHistoryWithClock(historyConfig, newFakeDeployer(shop), clock)
+	restarted.load()
+	assert.Equal(t, []time.Duration{90 * time.Second}, restarted.versions["main-uuid"].Durations)
+	assert.Equal(t, shop.Uuid, restarted.versions["main-uuid"].ProjectUuid)
+
+	assert.NoError(t, os.WriteFile(file, []byte("{not json"), 0o600))
+	broken := newHistoryWithClock(historyConfig, newFakeDeployer(shop), clock)
+	broken.load()
+	assert.Empty(t, broken.versions)
+}
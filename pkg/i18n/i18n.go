@@ -0,0 +1,234 @@
+// Package i18n translates the pages of catnip and the errors shown on them.
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// Catalogs contain the messages of all supported languages. A catalog is a JSON object mapping message keys to
+// formats, its file name is the language, e.g. de.json. Formats refer to their arguments by index, e.g. %[2]s, because
+// the order of the arguments may differ between languages.
+type Catalogs struct {
+	messages        map[string]map[string]string
+	languages       []string
+	defaultLanguage string
+	matcher         language.Matcher
+}
+
+// New loads the catalogs of all sources. Messages of later sources replace the ones with the same key and language,
+// so a theme can change single messages or add languages. Sources may be nil.
+func New(defaultLanguage string, sources ...fs.FS) (*Catalogs, error) {
+	messages := make(map[string]map[string]string)
+	for _, source := range sources {
+		if source == nil {
+			continue
+		}
+		names, err := fs.Glob(source, "*.json")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			lang := strings.ToLower(strings.TrimSuffix(path.Base(name), ".json"))
+			if _, err := language.Parse(lang); err != nil {
+				return nil, fmt.Errorf("catalog %s: %w", name, err)
+			}
+			content, err := fs.ReadFile(source, name)
+			if err != nil {
+				return nil, err
+			}
+			var catalog map[string]string
+			if err := json.Unmarshal(content, &catalog); err != nil {
+				return nil, fmt.Errorf("catalog %s: %w", name, err)
+			}
+			if messages[lang] == nil {
+				messages[lang] = make(map[string]string)
+			}
+			for key, format := range catalog {
+				messages[lang][key] = format
+			}
+		}
+	}
+
+	defaultLanguage = strings.ToLower(defaultLanguage)
+	if len(defaultLanguage) == 0 {
+		defaultLanguage = "en"
+	}
+	if _, ok := messages[defaultLanguage]; !ok {
+		return nil, fmt.Errorf("there is no catalog for the default language %q", defaultLanguage)
+	}
+
+	// the matcher falls back to its first language
+	languages := []string{defaultLanguage}
+	for lang := range messages {
+		if lang != defaultLanguage {
+			languages = append(languages, lang)
+		}
+	}
+	slices.Sort(languages[1:])
+	tags := make([]language.Tag, len(languages))
+	for i, lang := range languages {
+		tags[i] = language.MustParse(lang)
+	}
+
+	return &Catalogs{
+		messages:        messages,
+		languages:       languages,
+		defaultLanguage: defaultLanguage,
+		matcher:         language.NewMatcher(tags),
+	}, nil
+}
+
+// Languages returns the supported languages, the default language first.
+func (c *Catalogs) Languages() []string {
+	return slices.Clone(c.languages)
+}
+
+func (c *Catalogs) DefaultLanguage() string {
+	return c.defaultLanguage
+}
+
+// Supports reports whether there is a catalog for the language.
+func (c *Catalogs) Supports(lang string) bool {
+	_, ok := c.messages[strings.ToLower(lang)]
+	return ok
+}
+
+// Match picks the supported language preferred by the value of an Accept-Language header, e.g. de for
+// "de-CH, en;q=0.8". It returns the default language if none of them is supported.
+func (c *Catalogs) Match(acceptLanguage string) string {
+	preferred, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(preferred) == 0 {
+		return c.defaultLanguage
+	}
+	_, index, confidence := c.matcher.Match(preferred...)
+	if confidence == language.No {
+		return c.defaultLanguage
+	}
+	return c.languages[index]
+}
+
+// Translate formats the message with the arguments. Messages missing in the catalog of the language are taken from the
+// default language, the key is returned if neither contains it.
+func (c *Catalogs) Translate(lang string, key string, args ...any) string {
+	format, ok := c.format(lang, key)
+	if !ok {
+		return key
+	}
+	return apply(format, args...)
+}
+
+func apply(format string, args ...any) string {
+	// translations may leave out arguments, e.g. "1 day", which fmt would report as extra
+	if !strings.Contains(format, "%") {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+func (c *Catalogs) format(lang string, key string) (string, bool) {
+	format, ok := c.messages[strings.ToLower(lang)][key]
+	if !ok {
+		format, ok = c.messages[c.defaultLanguage][key]
+	}
+	return format, ok
+}
+
+// FormatDate formats the time using the Go layout of the key format.date.
+func (c *Catalogs) FormatDate(lang string, t time.Time) string {
+	layout, ok := c.format(lang, "format.date")
+	if !ok {
+		layout = time.RFC1123
+	}
+	return t.Format(layout)
+}
+
+// FormatDuration rounds the duration to its largest unit, e.g. "2 days" or "1 minute".
+func (c *Catalogs) FormatDuration(lang string, d time.Duration) string {
+	unit, count := largestUnit(d)
+	return c.Translate(lang, pluralKey("duration."+unit, count), count)
+}
+
+// FormatRelative describes the time relative to now, e.g. "3 hours ago" or "in 5 minutes". Languages whose words
+// change in relative times, like "vor 3 Tagen" in German, use keys like time.ago.day.other.
+func (c *Catalogs) FormatRelative(lang string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	d := time.Until(t)
+	if d > -time.Minute && d < time.Minute {
+		return c.Translate(lang, "time.justNow")
+	}
+	direction := "time.in"
+	if d < 0 {
+		direction, d = "time.ago", -d
+	}
+	unit, count := largestUnit(d)
+	if format, ok := c.messages[strings.ToLower(lang)][pluralKey(direction+"."+unit, count)]; ok {
+		return apply(format, count)
+	}
+	return c.Translate(lang, direction, c.FormatDuration(lang, d))
+}
+
+func largestUnit(d time.Duration) (string, int) {
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+		{"second", time.Second},
+	}
+	for _, unit := range units {
+		if d >= unit.size {
+			return unit.name, int(d / unit.size)
+		}
+	}
+	return "second", 0
+}
+
+func pluralKey(key string, count int) string {
+	if count == 1 {
+		return key + ".one"
+	}
+	return key + ".other"
+}
+
+// Error translates the message of the first Error in the chain of err. Other errors are not translated.
+func (c *Catalogs) Error(lang string, err error) string {
+	var translatable *Error
+	if errors.As(err, &translatable) {
+		return c.Translate(lang, translatable.Key, translatable.Args...)
+	}
+	return err.Error()
+}
+
+// Error is an error whose message can be translated. Its Error method returns the untranslated message, which is
+// what is logged.
+type Error struct {
+	Key  string
+	Args []any
+	err  error
+}
+
+// Errorf creates an error like fmt.Errorf, the message is translated using the key and the same arguments.
+func Errorf(key string, format string, args ...any) error {
+	return &Error{Key: key, Args: args, err: fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return errors.Unwrap(e.err)
+}
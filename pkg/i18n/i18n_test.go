@@ -0,0 +1,82 @@
+package i18n
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var builtin = fstest.MapFS{
+	"en.json": {Data: []byte(`{
+		"greeting": "Hello %[1]s",
+		"farewell": "Goodbye",
+		"error.notFound": "no version %[1]s in project %[2]s",
+		"time.ago": "%[1]s ago",
+		"duration.day.one": "1 day",
+		"duration.day.other": "%[1]d days"
+	}`)},
+	"de.json": {Data: []byte(`{
+		"greeting": "Hallo %[1]s",
+		"error.notFound": "Projekt %[2]s hat keine Version %[1]s",
+		"time.ago": "vor %[1]s",
+		"time.ago.day.other": "vor %[1]d Tagen",
+		"duration.day.one": "1 Tag",
+		"duration.day.other": "%[1]d Tage"
+	}`)},
+}
+
+func Test_Match_PicksTheBestSupportedLanguage(t *testing.T) {
+	catalogs, err := New("en", builtin, fstest.MapFS{"fr.json": {Data: []byte(`{"greeting": "Bonjour %[1]s"}`)}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"en", "de", "fr"}, catalogs.Languages())
+	assert.Equal(t, "de", catalogs.Match("de-CH, en;q=0.8"))
+	assert.Equal(t, "fr", catalogs.Match("it, fr-CA;q=0.9, de;q=0.5"))
+	assert.Equal(t, "en", catalogs.Match("ja"))
+	assert.Equal(t, "en", catalogs.Match(""))
+	assert.Equal(t, "en", catalogs.Match("not a language"))
+	assert.True(t, catalogs.Supports("DE"))
+	assert.False(t, catalogs.Supports("it"))
+}
+
+func Test_Translate_FallsBackToTheDefaultLanguage(t *testing.T) {
+	catalogs, err := New("en", builtin, fstest.MapFS{"de.json": {Data: []byte(`{"greeting": "Moin %[1]s"}`)}})
+	assert.NoError(t, err)
+
+	// the theme replaces single messages and keeps the others
+	assert.Equal(t, "Moin Katze", catalogs.Translate("de", "greeting", "Katze"))
+	assert.Equal(t, "Goodbye", catalogs.Translate("de", "farewell"))
+	assert.Equal(t, "missing", catalogs.Translate("de", "missing"))
+
+	_, err = New("it", builtin)
+	assert.Error(t, err)
+	_, err = New("en", fstest.MapFS{"en.json": {Data: []byte(`["not", "a", "catalog"]`)}})
+	assert.Error(t, err)
+}
+
+func Test_Error_TranslatesWrappedErrors(t *testing.T) {
+	catalogs, err := New("en", builtin)
+	assert.NoError(t, err)
+	errDenied := errors.New("denied")
+
+	translatable := Errorf("error.notFound", "did not find version %s in project %s: %w", "main", "shop", errDenied)
+	assert.Equal(t, "did not find version main in project shop: denied", translatable.Error())
+	assert.ErrorIs(t, translatable, errDenied)
+	assert.Equal(t, "Projekt shop hat keine Version main", catalogs.Error("de", fmt.Errorf("wake-up: %w", translatable)))
+	assert.Equal(t, "boom", catalogs.Error("de", errors.New("boom")))
+}
+
+func Test_FormatRelative_UsesTheWordsOfTheLanguage(t *testing.T) {
+	catalogs, err := New("en", builtin)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "3 days ago", catalogs.FormatRelative("en", time.Now().Add(-73*time.Hour)))
+	assert.Equal(t, "vor 3 Tagen", catalogs.FormatRelative("de", time.Now().Add(-73*time.Hour)))
+	assert.Equal(t, "vor 1 Tag", catalogs.FormatRelative("de", time.Now().Add(-25*time.Hour)))
+	assert.Equal(t, "3 Tage", catalogs.FormatDuration("de", 73*time.Hour))
+	assert.Equal(t, "Mon, 02 Jan 2006 15:04:05 UTC", catalogs.FormatDate("de", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)))
+}
@@ -0,0 +1,179 @@
+package idle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var protocolRegex = regexp.MustCompile("^https?://")
+
+// QuerySource asks a Prometheus compatible HTTP API whether a deployment has been used during the idle period, e.g.
+// based on the request metrics of its ingress. Requests going directly to a running deployment do not pass catnip, so
+// it would stop deployments in use without such a source.
+type QuerySource struct {
+	client    *http.Client
+	url       string
+	query     *template.Template
+	headers   map[string]string
+	threshold float64
+	window    string
+	clock     utils.Clock
+}
+
+// queryData is available to the query template. Window is the idle period as a Prometheus duration, e.g. 1800s.
+type queryData struct {
+	ProjectId string
+	Project   string
+	VersionId string
+	Version   string
+	Hosts     []string
+	Window    string
+}
+
+type queryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// sample is a timestamp and a value formatted as a string, as returned by the Prometheus API.
+type sample [2]any
+
+func NewQuerySource(idleConfig config.IdleConfig) (*QuerySource, error) {
+	return newQuerySourceWithClock(idleConfig, utils.NewClock())
+}
+
+func newQuerySourceWithClock(idleConfig config.IdleConfig, clock utils.Clock) (*QuerySource, error) {
+	sourceConfig := idleConfig.ActivitySource
+	if len(sourceConfig.Url) == 0 || len(sourceConfig.Query) == 0 {
+		return nil, errors.New("the activity source needs a url and a query")
+	}
+	query, err := template.New("query").Funcs(template.FuncMap{"join": strings.Join}).Parse(sourceConfig.Query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid activity query: %w", err)
+	}
+	return &QuerySource{
+		client:    &http.Client{Timeout: sourceConfig.Timeout},
+		url:       sourceConfig.Url,
+		query:     query,
+		headers:   sourceConfig.Headers,
+		threshold: sourceConfig.Threshold,
+		window:    fmt.Sprintf("%ds", int(idleConfig.IdlePeriod.Seconds())),
+		clock:     clock,
+	}, nil
+}
+
+// LastActivity runs the query for the version. A result above the threshold counts as activity at the time of the
+// query, an empty result or one up to the threshold as no known activity.
+func (s *QuerySource) LastActivity(ctx context.Context, project *oneko.Project, version *oneko.ProjectVersion) (time.Time, error) {
+	query, err := s.renderQuery(project, version)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := s.clock.Now()
+	values, err := s.run(ctx, query)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, value := range values {
+		if value > s.threshold {
+			return now, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+func (s *QuerySource) renderQuery(project *oneko.Project, version *oneko.ProjectVersion) (string, error) {
+	data := queryData{
+		ProjectId: project.Uuid,
+		Project:   project.Name,
+		VersionId: version.Uuid,
+		Version:   version.Name,
+		Window:    s.window,
+	}
+	for _, versionUrl := range version.Urls {
+		host, _, _ := strings.Cut(protocolRegex.ReplaceAllString(versionUrl, ""), "/")
+		data.Hosts = append(data.Hosts, strings.ToLower(host))
+	}
+	var query bytes.Buffer
+	if err := s.query.Execute(&query, data); err != nil {
+		return "", fmt.Errorf("failed to render the activity query: %w", err)
+	}
+	return query.String(), nil
+}
+
+// run sends the query and returns the values of all samples of the vector or scalar result.
+func (s *QuerySource) run(ctx context.Context, query string) ([]float64, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"?"+url.Values{"query": {query}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for header, value := range s.headers {
+		request.Header.Set(header, value)
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var result queryResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("unexpected activity query response (%d): %w", response.StatusCode, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("activity query failed (%d): %s", response.StatusCode, result.Error)
+	}
+
+	var samples []sample
+	switch result.Data.ResultType {
+	case "vector":
+		var vector []struct {
+			Value sample `json:"value"`
+		}
+		if err := json.Unmarshal(result.Data.Result, &vector); err != nil {
+			return nil, err
+		}
+		for _, element := range vector {
+			samples = append(samples, element.Value)
+		}
+	case "scalar":
+		var scalar sample
+		if err := json.Unmarshal(result.Data.Result, &scalar); err != nil {
+			return nil, err
+		}
+		samples = append(samples, scalar)
+	default:
+		return nil, fmt.Errorf("activity query returned a %s, expected a vector or scalar", result.Data.ResultType)
+	}
+
+	values := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		formatted, ok := sample[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("activity query returned an invalid value %v", sample[1])
+		}
+		value, err := strconv.ParseFloat(formatted, 64)
+		if err != nil {
+			return nil, fmt.Errorf("activity query returned an invalid value: %w", err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
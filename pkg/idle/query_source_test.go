@@ -0,0 +1,91 @@
+package idle
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newQuerySourceConfig(prometheusUrl string) config.IdleConfig {
+	return config.IdleConfig{
+		Enabled:    true,
+		IdlePeriod: 30 * time.Minute,
+		ActivitySource: config.ActivitySourceConfig{
+			Url:     prometheusUrl,
+			Query:   `sum(increase(requests_total{host=~"{{ join .Hosts "|" }}"}[{{ .Window }}]))`,
+			Headers: map[string]string{"Authorization": "Bearer s3cr3t"},
+			Timeout: time.Second,
+		},
+	}
+}
+
+func Test_QuerySource_ReportsActivityAboveTheThreshold(t *testing.T) {
+	var query, authorization string
+	response := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1709294400,"3"]}]}}`
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, authorization = r.URL.Query().Get("query"), r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	defer prometheus.Close()
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	source, err := newQuerySourceWithClock(newQuerySourceConfig(prometheus.URL), clock)
+	assert.NoError(t, err)
+	project := newProject("shop", "a")
+	project.Versions[0].Urls = []string{"https://Shop-A.example.com/cart", "http://a.shop.example.com"}
+
+	lastActivity, err := source.LastActivity(context.Background(), project, &project.Versions[0])
+
+	assert.NoError(t, err)
+	assert.Equal(t, clock.Now(), lastActivity)
+	assert.Equal(t, `sum(increase(requests_total{host=~"shop-a.example.com|a.shop.example.com"}[1800s]))`, query)
+	assert.Equal(t, "Bearer s3cr3t", authorization)
+
+	// values up to the threshold and empty results are no activity
+	response = `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1709294400,"0"]}]}}`
+	lastActivity, err = source.LastActivity(context.Background(), project, &project.Versions[0])
+	assert.NoError(t, err)
+	assert.True(t, lastActivity.IsZero())
+
+	response = `{"status":"success","data":{"resultType":"vector","result":[]}}`
+	lastActivity, err = source.LastActivity(context.Background(), project, &project.Versions[0])
+	assert.NoError(t, err)
+	assert.True(t, lastActivity.IsZero())
+
+	response = `{"status":"success","data":{"resultType":"scalar","result":[1709294400,"1"]}}`
+	lastActivity, err = source.LastActivity(context.Background(), project, &project.Versions[0])
+	assert.NoError(t, err)
+	assert.Equal(t, clock.Now(), lastActivity)
+}
+
+func Test_QuerySource_ReportsFailedQueries(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+	}))
+	defer prometheus.Close()
+	source, err := NewQuerySource(newQuerySourceConfig(prometheus.URL))
+	assert.NoError(t, err)
+	project := newProject("shop", "a")
+
+	_, err = source.LastActivity(context.Background(), project, &project.Versions[0])
+
+	assert.ErrorContains(t, err, "parse error")
+}
+
+func Test_NewQuerySource_RejectsIncompleteConfigurations(t *testing.T) {
+	_, err := NewQuerySource(config.IdleConfig{Enabled: true, IdlePeriod: 30 * time.Minute})
+	assert.Error(t, err)
+
+	invalidTemplate := newQuerySourceConfig("http://prometheus:9090/api/v1/query")
+	invalidTemplate.ActivitySource.Query = "{{ .Hosts"
+	_, err = NewQuerySource(invalidTemplate)
+	assert.Error(t, err)
+}
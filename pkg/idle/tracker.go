@@ -0,0 +1,252 @@
+package idle
+
+import (
+	"context"
+	"log/slog"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/logger"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Deployer is the part of the O-Neko service the tracker needs to start, check and stop deployments.
+type Deployer interface {
+	GetProjectAndVersionByIds(projectUuid, versionUuid string) (*oneko.Project, *oneko.ProjectVersion, error)
+	TriggerDeployment(projectId, versionId string, ctx context.Context) error
+	StopDeployment(projectId, versionId string, ctx context.Context) error
+}
+
+// ActivitySource reports when a deployment has last been used, e.g. based on the request metrics of its ingress. A
+// zero time means the source knows of no activity.
+type ActivitySource interface {
+	LastActivity(ctx context.Context, project *oneko.Project, version *oneko.ProjectVersion) (time.Time, error)
+}
+
+// ActivitySourceFunc adapts a function to an ActivitySource.
+type ActivitySourceFunc func(ctx context.Context, project *oneko.Project, version *oneko.ProjectVersion) (time.Time, error)
+
+func (f ActivitySourceFunc) LastActivity(ctx context.Context, project *oneko.Project, version *oneko.ProjectVersion) (time.Time, error) {
+	return f(ctx, project, version)
+}
+
+// wakeupGracePeriod is how long a woken deployment stays tracked while O-Neko does not report it as deployed yet.
+const wakeupGracePeriod = 5 * time.Minute
+
+const (
+	resultStopped = "stopped"
+	resultDryRun  = "dry_run"
+	resultFailed  = "failed"
+)
+
+// Tracker remembers the deployments catnip woke up and stops them once they have been idle for the configured
+// period. It wraps the Deployer, so every deployment triggered through it is tracked.
+type Tracker struct {
+	log             *slog.Logger
+	configuration   config.IdleConfig
+	deployer        Deployer
+	activitySources []ActivitySource
+	clock           utils.Clock
+	tracked         map[string]*trackedVersion
+	lock            sync.Mutex
+	stopCounter     *prometheus.CounterVec
+}
+
+type trackedVersion struct {
+	projectUuid string
+	versionUuid string
+	wokenAt     time.Time
+	lastSeen    time.Time
+	// seenDeployed is set once O-Neko reported the version as deployed
+	seenDeployed bool
+}
+
+func New(ctx context.Context, idleConfig config.IdleConfig, deployer Deployer) *Tracker {
+	t := newTrackerWithClock(idleConfig, deployer, utils.NewClock())
+	t.registerMetrics()
+	if idleConfig.Enabled {
+		go t.run(ctx)
+	}
+	return t
+}
+
+func newTrackerWithClock(idleConfig config.IdleConfig, deployer Deployer, clock utils.Clock) *Tracker {
+	return &Tracker{
+		log:           logger.New("idle"),
+		configuration: idleConfig,
+		deployer:      deployer,
+		clock:         clock,
+		tracked:       make(map[string]*trackedVersion),
+		stopCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "oneko_catnip_idle_stops_total",
+			Help: "The number of idle deployments stopped, or only reported in dry-run mode.",
+		}, []string{"result"}),
+	}
+}
+
+func (t *Tracker) registerMetrics() {
+	prometheus.MustRegister(
+		t.stopCounter,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "oneko_catnip_idle_tracked_deployments",
+			Help: "The number of deployments woken up by catnip which are watched for being idle.",
+		}, func() float64 {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			return float64(len(t.tracked))
+		}),
+	)
+}
+
+// AddActivitySource adds a source of activity besides the requests catnip sees itself. It must be called before any
+// deployment has been woken up.
+func (t *Tracker) AddActivitySource(source ActivitySource) {
+	t.activitySources = append(t.activitySources, source)
+}
+
+func (t *Tracker) GetProjectAndVersionByIds(projectUuid, versionUuid string) (*oneko.Project, *oneko.ProjectVersion, error) {
+	return t.deployer.GetProjectAndVersionByIds(projectUuid, versionUuid)
+}
+
+// TriggerDeployment triggers the deployment and starts tracking it if its project opted in to being stopped.
+func (t *Tracker) TriggerDeployment(projectId, versionId string, ctx context.Context) error {
+	if err := t.deployer.TriggerDeployment(projectId, versionId, ctx); err != nil {
+		return err
+	}
+	if !t.configuration.Enabled {
+		return nil
+	}
+	project, _, err := t.deployer.GetProjectAndVersionByIds(projectId, versionId)
+	if err != nil || !project.MatchesAny(t.configuration.Projects) {
+		return nil
+	}
+
+	now := t.clock.Now()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if tracked, ok := t.tracked[versionId]; ok {
+		tracked.lastSeen = now
+		return nil
+	}
+	t.tracked[versionId] = &trackedVersion{
+		projectUuid: projectId,
+		versionUuid: versionId,
+		wokenAt:     now,
+		lastSeen:    now,
+	}
+	return nil
+}
+
+// Seen records that catnip handled a request to the version.
+func (t *Tracker) Seen(versionUuid string) {
+	now := t.clock.Now()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if tracked, ok := t.tracked[versionUuid]; ok {
+		tracked.lastSeen = now
+	}
+}
+
+func (t *Tracker) run(ctx context.Context) {
+	ticker := time.NewTicker(t.configuration.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.stopIdleDeployments(ctx)
+		}
+	}
+}
+
+// stopIdleDeployments stops the tracked deployments without any activity during the idle period. Deployments stopped
+// by someone else are no longer tracked. Just woken deployments are reported as not deployed until O-Neko has picked
+// them up, so they are only forgotten once the wakeupGracePeriod is over.
+func (t *Tracker) stopIdleDeployments(ctx context.Context) {
+	t.lock.Lock()
+	candidates := make([]trackedVersion, 0, len(t.tracked))
+	for _, tracked := range t.tracked {
+		candidates = append(candidates, *tracked)
+	}
+	t.lock.Unlock()
+
+	for _, candidate := range candidates {
+		project, version, err := t.deployer.GetProjectAndVersionByIds(candidate.projectUuid, candidate.versionUuid)
+		if err != nil {
+			t.log.Warn("failed to check the state of a woken deployment", slog.String("versionId", candidate.versionUuid), slog.Any("error", err))
+			continue
+		}
+		if !version.IsDeployed() {
+			if candidate.seenDeployed || t.clock.Now().Sub(candidate.wokenAt) >= wakeupGracePeriod {
+				t.forget(candidate.versionUuid)
+			}
+			continue
+		}
+		t.markDeployed(candidate.versionUuid)
+
+		lastActivity, known := t.lastActivity(ctx, candidate, project, version)
+		if !known {
+			// the deployment may be in use, it is checked again next time
+			continue
+		}
+		idleFor := t.clock.Now().Sub(lastActivity)
+		if idleFor < t.configuration.IdlePeriod {
+			continue
+		}
+
+		if t.configuration.DryRun {
+			t.log.Info("deployment is idle and would be stopped", slog.String("project", project.Name), slog.String("version", version.Name), slog.Duration("idleFor", idleFor))
+			t.stopCounter.WithLabelValues(resultDryRun).Inc()
+			t.forget(candidate.versionUuid)
+			continue
+		}
+
+		t.log.Info("stopping idle deployment", slog.String("project", project.Name), slog.String("version", version.Name), slog.Duration("idleFor", idleFor))
+		if err := t.deployer.StopDeployment(project.Uuid, version.Uuid, ctx); err != nil {
+			t.stopCounter.WithLabelValues(resultFailed).Inc()
+			continue
+		}
+		t.stopCounter.WithLabelValues(resultStopped).Inc()
+		t.forget(candidate.versionUuid)
+	}
+}
+
+// lastActivity reports whether all activity sources could be asked.
+func (t *Tracker) lastActivity(ctx context.Context, candidate trackedVersion, project *oneko.Project, version *oneko.ProjectVersion) (time.Time, bool) {
+	t.lock.Lock()
+	lastActivity := candidate.wokenAt
+	if tracked, ok := t.tracked[candidate.versionUuid]; ok && tracked.lastSeen.After(lastActivity) {
+		lastActivity = tracked.lastSeen
+	}
+	t.lock.Unlock()
+
+	for _, source := range t.activitySources {
+		activity, err := source.LastActivity(ctx, project, version)
+		if err != nil {
+			t.log.Warn("failed to get the activity of a deployment", slog.String("version", version.Name), slog.Any("error", err))
+			return lastActivity, false
+		}
+		if activity.After(lastActivity) {
+			lastActivity = activity
+		}
+	}
+	return lastActivity, true
+}
+
+func (t *Tracker) markDeployed(versionUuid string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if tracked, ok := t.tracked[versionUuid]; ok {
+		tracked.seenDeployed = true
+	}
+}
+
+func (t *Tracker) forget(versionUuid string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.tracked, versionUuid)
+}
@@ -0,0 +1,255 @@
+package idle
+
+import (
+	"context"
+	"fmt"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	config.OverrideConfiguration(&config.Config{
+		ONeko: config.ONekoConfig{
+			Mode: "production",
+			Logging: config.LoggingConfig{
+				Level: "error",
+			},
+		},
+	})
+	os.Exit(m.Run())
+}
+
+// fakeDeployer deploys and stops versions immediately.
+type fakeDeployer struct {
+	projects map[string]*oneko.Project
+	stopped  []string
+	failStop bool
+	lock     sync.Mutex
+}
+
+func newFakeDeployer(projects ...*oneko.Project) *fakeDeployer {
+	d := &fakeDeployer{projects: make(map[string]*oneko.Project)}
+	for _, project := range projects {
+		d.projects[project.Uuid] = project
+	}
+	return d
+}
+
+func (d *fakeDeployer) GetProjectAndVersionByIds(projectUuid, versionUuid string) (*oneko.Project, *oneko.ProjectVersion, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	project, ok := d.projects[projectUuid]
+	if !ok {
+		return nil, nil, fmt.Errorf("no project found with id %s", projectUuid)
+	}
+	version := project.GetProjectVersionMatchingUuid(versionUuid)
+	if version == nil {
+		return nil, nil, fmt.Errorf("did not find version with id %s", versionUuid)
+	}
+	return project, version, nil
+}
+
+func (d *fakeDeployer) TriggerDeployment(projectId, versionId string, ctx context.Context) error {
+	d.setDesiredState(projectId, versionId, oneko.Deployed)
+	return nil
+}
+
+func (d *fakeDeployer) StopDeployment(projectId, versionId string, ctx context.Context) error {
+	if d.failStop {
+		return fmt.Errorf("O-Neko is down")
+	}
+	d.setDesiredState(projectId, versionId, oneko.NotDeployed)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.stopped = append(d.stopped, versionId)
+	return nil
+}
+
+func (d *fakeDeployer) setDesiredState(projectId, versionId string, state oneko.DesiredState) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	versions := d.projects[projectId].Versions
+	for i := range versions {
+		if versions[i].Uuid == versionId {
+			versions[i].DesiredState = state
+		}
+	}
+}
+
+func newProject(name string, versions ...string) *oneko.Project {
+	project := &oneko.Project{Uuid: name, Name: name}
+	for _, version := range versions {
+		project.Versions = append(project.Versions, oneko.ProjectVersion{
+			Uuid:         name + "-" + version,
+			Name:         version,
+			DesiredState: oneko.NotDeployed,
+		})
+	}
+	return project
+}
+
+var idleConfig = config.IdleConfig{
+	Enabled:    true,
+	Projects:   []string{"shop"},
+	IdlePeriod: 30 * time.Minute,
+}
+
+func Test_StopIdleDeployments_StopsDeploymentsWithoutTraffic(t *testing.T) {
+	shop := newProject("shop", "a", "b")
+	deployer := newFakeDeployer(shop)
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tracker := newTrackerWithClock(idleConfig, deployer, clock)
+
+	assert.NoError(t, tracker.TriggerDeployment("shop", "shop-a", context.Background()))
+	assert.NoError(t, tracker.TriggerDeployment("shop", "shop-b", context.Background()))
+
+	clock.TimeTravel(20 * time.Minute)
+	tracker.Seen("shop-b")
+	tracker.stopIdleDeployments(context.Background())
+	assert.Empty(t, deployer.stopped)
+
+	clock.TimeTravel(15 * time.Minute)
+	tracker.stopIdleDeployments(context.Background())
+	assert.Equal(t, []string{"shop-a"}, deployer.stopped)
+	assert.Equal(t, float64(1), testutil.ToFloat64(tracker.stopCounter.WithLabelValues(resultStopped)))
+
+	clock.TimeTravel(20 * time.Minute)
+	tracker.stopIdleDeployments(context.Background())
+	assert.Equal(t, []string{"shop-a", "shop-b"}, deployer.stopped)
+	assert.Empty(t, tracker.tracked)
+}
+
+func Test_StopIdleDeployments_ConsultsActivitySources(t *testing.T) {
+	shop := newProject("shop", "a")
+	deployer := newFakeDeployer(shop)
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tracker := newTrackerWithClock(idleConfig, deployer, clock)
+	tracker.AddActivitySource(ActivitySourceFunc(func(ctx context.Context, project *oneko.Project, version *oneko.ProjectVersion) (time.Time, error) {
+		// the deployment is used directly, without passing catnip
+		return clock.Now().Add(-time.Minute), nil
+	}))
+
+	assert.NoError(t, tracker.TriggerDeployment("shop", "shop-a", context.Background()))
+	clock.TimeTravel(time.Hour)
+	tracker.stopIdleDeployments(context.Background())
+
+	assert.Empty(t, deployer.stopped)
+}
+
+func Test_StopIdleDeployments_KeepsDeploymentsWhoseActivityIsUnknown(t *testing.T) {
+	shop := newProject("shop", "a")
+	deployer := newFakeDeployer(shop)
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tracker := newTrackerWithClock(idleConfig, deployer, clock)
+	var sourceDown atomic.Bool
+	sourceDown.Store(true)
+	tracker.AddActivitySource(ActivitySourceFunc(func(ctx context.Context, project *oneko.Project, version *oneko.ProjectVersion) (time.Time, error) {
+		if sourceDown.Load() {
+			return time.Time{}, fmt.Errorf("prometheus is down")
+		}
+		return time.Time{}, nil
+	}))
+
+	assert.NoError(t, tracker.TriggerDeployment("shop", "shop-a", context.Background()))
+	clock.TimeTravel(time.Hour)
+	tracker.stopIdleDeployments(context.Background())
+	assert.Empty(t, deployer.stopped)
+
+	sourceDown.Store(false)
+	tracker.stopIdleDeployments(context.Background())
+	assert.Equal(t, []string{"shop-a"}, deployer.stopped)
+}
+
+func Test_StopIdleDeployments_OnlyReportsInDryRun(t *testing.T) {
+	shop := newProject("shop", "a")
+	deployer := newFakeDeployer(shop)
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	dryRunConfig := idleConfig
+	dryRunConfig.DryRun = true
+	tracker := newTrackerWithClock(dryRunConfig, deployer, clock)
+
+	assert.NoError(t, tracker.TriggerDeployment("shop", "shop-a", context.Background()))
+	clock.TimeTravel(time.Hour)
+	tracker.stopIdleDeployments(context.Background())
+
+	assert.Empty(t, deployer.stopped)
+	assert.True(t, shop.Versions[0].IsDeployed())
+	assert.Equal(t, float64(1), testutil.ToFloat64(tracker.stopCounter.WithLabelValues(resultDryRun)))
+	assert.Empty(t, tracker.tracked)
+}
+
+func Test_TriggerDeployment_TracksOptedInProjectsOnly(t *testing.T) {
+	shop := newProject("shop", "a")
+	wiki := newProject("wiki", "a")
+	deployer := newFakeDeployer(shop, wiki)
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tracker := newTrackerWithClock(idleConfig, deployer, clock)
+
+	assert.NoError(t, tracker.TriggerDeployment("shop", "shop-a", context.Background()))
+	assert.NoError(t, tracker.TriggerDeployment("wiki", "wiki-a", context.Background()))
+	clock.TimeTravel(time.Hour)
+	tracker.stopIdleDeployments(context.Background())
+
+	assert.Equal(t, []string{"shop-a"}, deployer.stopped)
+	assert.True(t, wiki.Versions[0].IsDeployed())
+}
+
+func Test_StopIdleDeployments_RetriesFailedStops(t *testing.T) {
+	shop := newProject("shop", "a")
+	deployer := newFakeDeployer(shop)
+	deployer.failStop = true
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tracker := newTrackerWithClock(idleConfig, deployer, clock)
+
+	assert.NoError(t, tracker.TriggerDeployment("shop", "shop-a", context.Background()))
+	clock.TimeTravel(time.Hour)
+	tracker.stopIdleDeployments(context.Background())
+	assert.Equal(t, float64(1), testutil.ToFloat64(tracker.stopCounter.WithLabelValues(resultFailed)))
+
+	deployer.failStop = false
+	tracker.stopIdleDeployments(context.Background())
+	assert.Equal(t, []string{"shop-a"}, deployer.stopped)
+}
+
+func Test_StopIdleDeployments_ForgetsDeploymentsStoppedElsewhere(t *testing.T) {
+	shop := newProject("shop", "a")
+	deployer := newFakeDeployer(shop)
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tracker := newTrackerWithClock(idleConfig, deployer, clock)
+
+	assert.NoError(t, tracker.TriggerDeployment("shop", "shop-a", context.Background()))
+	tracker.stopIdleDeployments(context.Background())
+	deployer.setDesiredState("shop", "shop-a", oneko.NotDeployed)
+	tracker.stopIdleDeployments(context.Background())
+
+	assert.Empty(t, tracker.tracked)
+	assert.Empty(t, deployer.stopped)
+}
+
+func Test_StopIdleDeployments_KeepsTrackingDeploymentsNotPickedUpByONekoYet(t *testing.T) {
+	shop := newProject("shop", "a")
+	deployer := newFakeDeployer(shop)
+	clock := utils.NewTimeMachineAt(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tracker := newTrackerWithClock(idleConfig, deployer, clock)
+
+	assert.NoError(t, tracker.TriggerDeployment("shop", "shop-a", context.Background()))
+	// the cached project still reports the triggered version as not deployed
+	deployer.setDesiredState("shop", "shop-a", oneko.NotDeployed)
+	clock.TimeTravel(wakeupGracePeriod - time.Second)
+	tracker.stopIdleDeployments(context.Background())
+	assert.Contains(t, tracker.tracked, "shop-a")
+
+	clock.TimeTravel(time.Second)
+	tracker.stopIdleDeployments(context.Background())
+	assert.Empty(t, tracker.tracked)
+	assert.Empty(t, deployer.stopped)
+}
@@ -202,3 +202,23 @@ func (api *Api) Deploy(projectId, versionId string, ctx context.Context) error {
 	api.wakeupCounter.Inc()
 	return nil
 }
+
+// Stop asks O-Neko to stop the deployment of the version.
+func (api *Api) Stop(projectId, versionId string, ctx context.Context) error {
+	timer := prometheus.NewTimer(api.apiCallDuration)
+	defer timer.ObserveDuration()
+	response, err := api.client.R().
+		SetContext(ctx).
+		Post(fmt.Sprintf("/api/project/%s/version/%s/stop", projectId, versionId))
+
+	if err != nil {
+		return err
+	} else if response.IsError() {
+		if response.StatusCode() == http.StatusNotFound {
+			return fmt.Errorf(response.String())
+		} else {
+			return fmt.Errorf("encountered an error calling O-Neko API: %s (%d)", response.Status(), response.StatusCode())
+		}
+	}
+	return nil
+}
@@ -126,6 +126,20 @@ func Test_Deploy(t *testing.T) {
 	assert.Equal(t, 1, wakeupCallCount)
 }
 
+func Test_Stop(t *testing.T) {
+	beforeEach()
+	defer afterEach(t)
+	setupProjectResponders(t)
+
+	err := uut.Stop(projectUuid, versionUuid, context.Background())
+
+	assert.NoError(t, err)
+
+	stopCallCount := httpmock.GetCallCountInfo()[fmt.Sprintf("POST /api/project/%s/version/%s/stop", projectUuid, versionUuid)]
+
+	assert.Equal(t, 1, stopCallCount)
+}
+
 func Test_GetProjectAndVersionByIds(t *testing.T) {
 	beforeEach()
 	defer afterEach(t)
@@ -163,6 +177,9 @@ func setupProjectResponders(t *testing.T) {
 	wakeupResponder := httpmock.NewStringResponder(http.StatusOK, "")
 	httpmock.RegisterResponder("POST", fmt.Sprintf("/api/project/%s/version/%s/deploy", projectUuid, versionUuid), wakeupResponder)
 
+	stopResponder := httpmock.NewStringResponder(http.StatusOK, "")
+	httpmock.RegisterResponder("POST", fmt.Sprintf("/api/project/%s/version/%s/stop", projectUuid, versionUuid), stopResponder)
+
 	getAllProjectsResponder, err := httpmock.NewJsonResponder(http.StatusOK, []*oneko.Project{demoProject, demoProject2})
 	assert.NoError(t, err)
 	httpmock.RegisterResponder("GET", "https://oneko.com/api/project", getAllProjectsResponder)
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"strings"
+	"time"
+)
+
+// urlIndex is an immutable snapshot of the projects known to O-Neko and the URLs of their versions. It is replaced
+// as a whole by the indexer, so it can be read without locking.
+type urlIndex struct {
+	createdAt time.Time
+	// stale indexes have been read from the index file and not been confirmed by O-Neko yet
+	stale            bool
+	projects         map[string]*oneko.Project
+	urlPrefixes      map[string]projectAndVersionIds
+	projectIdsByName map[string]string
+	domains          *utils.Set[string]
+	// projects loaded from O-Neko one by one since the index has been created and when they have been loaded
+	refreshedAt map[string]time.Time
+}
+
+func newUrlIndex(projects []*oneko.Project, createdAt time.Time) *urlIndex {
+	index := &urlIndex{
+		createdAt:        createdAt,
+		projects:         make(map[string]*oneko.Project, len(projects)),
+		refreshedAt:      make(map[string]time.Time),
+		urlPrefixes:      make(map[string]projectAndVersionIds),
+		projectIdsByName: make(map[string]string, len(projects)),
+		domains:          utils.NewSet[string](),
+	}
+	for _, project := range projects {
+		index.projects[project.Uuid] = project
+		index.projectIdsByName[strings.ToLower(project.Name)] = project.Uuid
+		for _, version := range project.Versions {
+			for _, url := range version.Urls {
+				prefix := GetDeploymentUrlPrefix(url)
+				index.urlPrefixes[prefix] = projectAndVersionIds{
+					project:        project.Uuid,
+					projectVersion: version.Uuid,
+				}
+				host, _, _ := strings.Cut(prefix, "/")
+				index.domains.Add(host)
+			}
+		}
+	}
+	return index
+}
+
+var emptyUrlIndex = newUrlIndex(nil, time.Time{})
+
+// withProject returns a copy of the index with the project added or replaced by a version loaded at refreshedAt.
+func (i *urlIndex) withProject(project *oneko.Project, refreshedAt time.Time) *urlIndex {
+	projects := make([]*oneko.Project, 0, len(i.projects)+1)
+	for id, indexed := range i.projects {
+		if id != project.Uuid {
+			projects = append(projects, indexed)
+		}
+	}
+	projects = append(projects, project)
+
+	index := newUrlIndex(projects, i.createdAt)
+	index.stale = i.stale
+	index.refreshedAt = maps.Clone(i.refreshedAt)
+	index.refreshedAt[project.Uuid] = refreshedAt
+	return index
+}
+
+// loadedAt returns when the project has been loaded from O-Neko.
+func (i *urlIndex) loadedAt(projectId string) time.Time {
+	if refreshedAt, ok := i.refreshedAt[projectId]; ok {
+		return refreshedAt
+	}
+	return i.createdAt
+}
+
+// isStale reports whether the project has been read from the index file and not been confirmed by O-Neko yet.
+func (i *urlIndex) isStale(projectId string) bool {
+	_, refreshed := i.refreshedAt[projectId]
+	return i.stale && !refreshed
+}
+
+// findLongestPrefix looks up the prefix and all of its parent paths. Hosts with a port are looked up without it as
+// well, the Host header may contain a port the URLs of the version leave out, e.g. the default one.
+func (i *urlIndex) findLongestPrefix(prefix string) (string, projectAndVersionIds, bool) {
+	if matchedPrefix, ids, found := i.findLongestPath(prefix); found {
+		return matchedPrefix, ids, true
+	}
+	host, path, hasPath := strings.Cut(prefix, "/")
+	if withoutPort := GetHostWithoutPort(host); withoutPort != host {
+		if hasPath {
+			return i.findLongestPath(withoutPort + "/" + path)
+		}
+		return i.findLongestPath(withoutPort)
+	}
+	return "", projectAndVersionIds{}, false
+}
+
+func (i *urlIndex) findLongestPath(prefix string) (string, projectAndVersionIds, bool) {
+	for candidate := prefix; ; {
+		if ids, ok := i.urlPrefixes[candidate]; ok {
+			return candidate, ids, true
+		}
+		lastSlash := strings.LastIndex(candidate, "/")
+		if lastSlash < 0 {
+			return "", projectAndVersionIds{}, false
+		}
+		candidate = candidate[:lastSlash]
+	}
+}
+
+func (o *Service) currentIndex() *urlIndex {
+	return o.index.Load()
+}
+
+// runIndexer refreshes the index periodically and whenever a lookup of an unknown URL requested it, but not more
+// often than every MinIndexRebuildInterval. Single projects are refreshed as requested. The indexer is the only one
+// calling the O-Neko API to load projects, so requests never wait for it.
+func (o *Service) runIndexer(ctx context.Context) {
+	ticker := time.NewTicker(o.indexRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case projectId := <-o.projectRefreshRequests:
+			o.pendingProjectRefreshes.Delete(projectId)
+			_ = o.refreshProject(ctx, projectId)
+			continue
+		case <-ticker.C:
+		case <-o.indexRefreshRequests:
+			if time.Since(o.lastIndexRebuild) < o.minIndexRebuildInterval {
+				o.log.Debug("not rebuilding the url index, it has been rebuilt recently")
+				continue
+			}
+		}
+		o.lastIndexRebuild = time.Now()
+		_ = o.refreshIndex(ctx)
+	}
+}
+
+// refreshIndex loads all projects from O-Neko and swaps in a new index. The previous index stays in use if O-Neko
+// cannot be reached.
+func (o *Service) refreshIndex(ctx context.Context) error {
+	o.indexRebuildCounter.Inc()
+	projects, err := o.api.GetAllProjects(ctx)
+	if err != nil {
+		o.log.Warn("failed to refresh the url index, keeping the previous one", slog.Time("indexCreatedAt", o.currentIndex().createdAt), slog.Any("error", err))
+		return err
+	}
+	createdAt := time.Now()
+	o.index.Store(newUrlIndex(projects, createdAt))
+	if len(o.indexFile) > 0 {
+		if err := writeIndexFile(o.indexFile, projects, createdAt); err != nil {
+			o.log.Warn("failed to persist the url index", slog.String("file", o.indexFile), slog.Any("error", err))
+		}
+	}
+	o.log.Debug("refreshed the url index", slog.Int("projects", len(projects)))
+	return nil
+}
+
+// refreshProject loads a single project from O-Neko and swaps in an index containing it, e.g. to pick up the status of
+// a version after triggering its deployment. The indexed project stays in use if O-Neko cannot be reached.
+func (o *Service) refreshProject(ctx context.Context, projectId string) error {
+	o.log.Debug("loading project from o-neko", slog.String("projectId", projectId))
+	project, err := o.api.GetProjectById(projectId, ctx)
+	if err != nil {
+		o.log.Warn("failed to refresh the project, keeping the indexed one", slog.String("projectId", projectId), slog.Any("error", err))
+		return err
+	}
+	o.index.Store(o.currentIndex().withProject(project, time.Now()))
+	return nil
+}
+
+// requestProjectRefresh asks the indexer to load the project from O-Neko soon. Requests for a project which is already
+// waiting to be refreshed are dropped, as are all requests while the indexer is busy. It never blocks.
+func (o *Service) requestProjectRefresh(projectId string) {
+	if _, pending := o.pendingProjectRefreshes.LoadOrStore(projectId, true); pending {
+		return
+	}
+	select {
+	case o.projectRefreshRequests <- projectId:
+	default:
+		o.pendingProjectRefreshes.Delete(projectId)
+	}
+}
+
+// requestIndexRefresh asks the indexer to refresh the index soon, e.g. to pick up projects created since the last
+// refresh. It never blocks.
+func (o *Service) requestIndexRefresh() {
+	select {
+	case o.indexRefreshRequests <- struct{}{}:
+	default:
+		o.coalescedIndexLoadCounter.Inc()
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"os"
+	"time"
+)
+
+// indexFile is the content of the file the last good index is persisted in, so catnip can route requests right
+// after a restart even if O-Neko cannot be reached.
+type indexFile struct {
+	CreatedAt time.Time        `json:"createdAt"`
+	Projects  []*oneko.Project `json:"projects"`
+}
+
+// readIndexFile returns a stale index built from the persisted projects, or nil if there is no file yet.
+func readIndexFile(path string) (*urlIndex, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var file indexFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid index file %s: %w", path, err)
+	}
+	index := newUrlIndex(file.Projects, file.CreatedAt)
+	index.stale = true
+	return index, nil
+}
+
+// writeIndexFile replaces the file atomically.
+func writeIndexFile(path string, projects []*oneko.Project, createdAt time.Time) error {
+	content, err := json.Marshal(indexFile{
+		CreatedAt: createdAt,
+		Projects:  projects,
+	})
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomically(path, content)
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"o-neko-catnip/pkg/oneko"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IndexFile_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	createdAt := time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC)
+	projects := []*oneko.Project{
+		{
+			Uuid: "p",
+			Name: "Shop",
+			Versions: []oneko.ProjectVersion{
+				{Uuid: "a", Name: "main", Urls: []string{"https://shop.example.com"}, DesiredState: oneko.NotDeployed},
+			},
+		},
+	}
+
+	assert.NoError(t, writeIndexFile(path, projects, createdAt))
+	// overwriting replaces the file without leaving temporary files behind
+	assert.NoError(t, writeIndexFile(path, projects, createdAt))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	index, err := readIndexFile(path)
+	if assert.NoError(t, err) && assert.NotNil(t, index) {
+		assert.True(t, index.stale)
+		assert.True(t, createdAt.Equal(index.createdAt))
+		assert.True(t, index.domains.Contains("shop.example.com"))
+		assert.Equal(t, oneko.NotDeployed, index.projects["p"].Versions[0].DesiredState)
+	}
+}
+
+func Test_ReadIndexFile(t *testing.T) {
+	index, err := readIndexFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NoError(t, err)
+	assert.Nil(t, index)
+
+	corrupt := filepath.Join(t.TempDir(), "corrupt.json")
+	assert.NoError(t, os.WriteFile(corrupt, []byte("{"), 0o600))
+	_, err = readIndexFile(corrupt)
+	assert.Error(t, err)
+}
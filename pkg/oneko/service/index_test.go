@@ -0,0 +1,77 @@
+package service
+
+import (
+	"o-neko-catnip/pkg/oneko"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FindLongestPrefix(t *testing.T) {
+	index := newUrlIndex([]*oneko.Project{
+		{
+			Uuid: "p",
+			Name: "Shop",
+			Versions: []oneko.ProjectVersion{
+				{Uuid: "root", Urls: []string{"https://preview.example.com"}},
+				{Uuid: "a", Urls: []string{"https://preview.example.com/shop-a"}},
+				{Uuid: "b", Urls: []string{"https://preview.example.com/shop-b"}},
+				{Uuid: "b-admin", Urls: []string{"https://preview.example.com/shop-b/admin"}},
+			},
+		},
+	}, time.Now())
+
+	assertPrefix := func(url, expectedPrefix, expectedVersion string) {
+		prefix, ids, found := index.findLongestPrefix(GetDeploymentUrlPrefix(url))
+		if assert.True(t, found, url) {
+			assert.Equal(t, expectedPrefix, prefix, url)
+			assert.Equal(t, expectedVersion, ids.projectVersion, url)
+		}
+	}
+
+	assertPrefix("https://preview.example.com/shop-a/cart?tab=checkout", "preview.example.com/shop-a", "a")
+	assertPrefix("https://preview.example.com/shop-b", "preview.example.com/shop-b", "b")
+	assertPrefix("https://preview.example.com/shop-b/admin/users", "preview.example.com/shop-b/admin", "b-admin")
+	assertPrefix("https://preview.example.com/shop-ab", "preview.example.com", "root")
+	assertPrefix("https://preview.example.com/", "preview.example.com", "root")
+
+	_, _, found := index.findLongestPrefix(GetDeploymentUrlPrefix("https://other.example.com/shop-a"))
+	assert.False(t, found)
+
+	assert.Equal(t, 1, index.domains.Size())
+	assert.Equal(t, "p", index.projectIdsByName["shop"])
+}
+
+func Test_WithProject_ReplacesTheProjectAndConfirmsIt(t *testing.T) {
+	createdAt := time.Now().Add(-time.Hour)
+	index := newUrlIndex([]*oneko.Project{
+		{Uuid: "shop", Name: "Shop", Versions: []oneko.ProjectVersion{{Uuid: "main", Urls: []string{"https://shop.example.com"}}}},
+		{Uuid: "blog", Name: "Blog", Versions: []oneko.ProjectVersion{{Uuid: "draft", Urls: []string{"https://blog.example.com"}}}},
+	}, createdAt)
+	index.stale = true
+
+	refreshedAt := time.Now()
+	refreshed := index.withProject(&oneko.Project{
+		Uuid: "shop", Name: "Shop", Versions: []oneko.ProjectVersion{{Uuid: "main", Urls: []string{"https://store.example.com"}}},
+	}, refreshedAt)
+
+	_, ids, found := refreshed.findLongestPrefix("store.example.com")
+	if assert.True(t, found) {
+		assert.Equal(t, "main", ids.projectVersion)
+	}
+	_, _, found = refreshed.findLongestPrefix("shop.example.com")
+	assert.False(t, found)
+	_, _, found = refreshed.findLongestPrefix("blog.example.com")
+	assert.True(t, found)
+
+	assert.False(t, refreshed.isStale("shop"))
+	assert.True(t, refreshed.isStale("blog"))
+	assert.Equal(t, refreshedAt, refreshed.loadedAt("shop"))
+	assert.Equal(t, createdAt, refreshed.loadedAt("blog"))
+
+	// the index itself is never changed
+	assert.True(t, index.isStale("shop"))
+	_, _, found = index.findLongestPrefix("shop.example.com")
+	assert.True(t, found)
+}
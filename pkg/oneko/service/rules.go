@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/i18n"
+	"o-neko-catnip/pkg/oneko"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type RuleAction string
+
+const (
+	RuleAllow    RuleAction = "allow"
+	RuleDeny     RuleAction = "deny"
+	RuleConfirm  RuleAction = "confirm"
+	RuleRedirect RuleAction = "redirect"
+)
+
+// ErrDeniedByRule is returned when triggering the deployment of a version which must not be woken up.
+var ErrDeniedByRule = errors.New("denied by rule")
+
+// RuleDecision is the outcome of the rules for a version. RedirectTo is only set for redirects.
+type RuleDecision struct {
+	Action     RuleAction
+	RedirectTo string
+	Reason     string
+	Contact    string
+}
+
+// Allowed reports whether the version may be woken up, possibly after a confirmation.
+func (d RuleDecision) Allowed() bool {
+	return d.Action != RuleDeny && d.Action != RuleRedirect
+}
+
+type rule struct {
+	projects []patternMatcher
+	versions []patternMatcher
+	decision RuleDecision
+}
+
+type patternMatcher func(value string) bool
+
+func newRules(ruleConfigs []config.RuleConfig) ([]rule, error) {
+	rules := make([]rule, 0, len(ruleConfigs))
+	for i, ruleConfig := range ruleConfigs {
+		projects, err := newPatternMatchers(ruleConfig.Projects)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		versions, err := newPatternMatchers(ruleConfig.Versions)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		rules = append(rules, rule{
+			projects: projects,
+			versions: versions,
+			decision: RuleDecision{
+				Action:     RuleAction(ruleConfig.Action),
+				RedirectTo: ruleConfig.RedirectTo,
+				Reason:     ruleConfig.Reason,
+				Contact:    ruleConfig.Contact,
+			},
+		})
+	}
+	return rules, nil
+}
+
+// newPatternMatchers compiles regular expressions enclosed in slashes as they are and glob patterns to match values
+// regardless of their case.
+func newPatternMatchers(patterns []string) ([]patternMatcher, error) {
+	matchers := make([]patternMatcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		if config.IsRegexpPattern(pattern) {
+			expression, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, expression.MatchString)
+			continue
+		}
+		glob := strings.ToLower(pattern)
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+		matchers = append(matchers, func(value string) bool {
+			matched, _ := path.Match(glob, strings.ToLower(value))
+			return matched
+		})
+	}
+	return matchers, nil
+}
+
+func matchesAny(matchers []patternMatcher, values ...string) bool {
+	if len(matchers) == 0 {
+		return true
+	}
+	for _, matches := range matchers {
+		for _, value := range values {
+			if matches(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r rule) matches(project *oneko.Project, version *oneko.ProjectVersion) bool {
+	return matchesAny(r.projects, project.Name, project.Uuid) && matchesAny(r.versions, version.Name)
+}
+
+// EvaluateRules returns the decision of the first configured rule matching the version. Versions without a matching
+// rule are allowed.
+func (o *Service) EvaluateRules(project *oneko.Project, version *oneko.ProjectVersion) RuleDecision {
+	for _, r := range o.rules {
+		if r.matches(project, version) {
+			return r.decision
+		}
+	}
+	return RuleDecision{Action: RuleAllow}
+}
+
+// checkRules makes sure no version denied by a rule is triggered, regardless of the way the wake-up was requested.
+func (o *Service) checkRules(projectId, versionId string) error {
+	if len(o.rules) == 0 {
+		return nil
+	}
+	project, version, err := o.GetProjectAndVersionByIds(projectId, versionId)
+	if err != nil {
+		return err
+	}
+	if decision := o.EvaluateRules(project, version); !decision.Allowed() {
+		return i18n.Errorf("error.deniedByRule", "waking up version %s of project %s is %w", version.Name, project.Name, ErrDeniedByRule)
+	}
+	return nil
+}
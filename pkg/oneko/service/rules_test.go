@@ -0,0 +1,50 @@
+package service
+
+import (
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EvaluateRules_FirstMatchingRuleApplies(t *testing.T) {
+	rules, err := newRules([]config.RuleConfig{
+		{Projects: []string{"shop"}, Versions: []string{"/^release-[0-9.]+$/"}, Action: "allow"},
+		{Projects: []string{"shop"}, Versions: []string{"release-*"}, Action: "deny", Reason: "Releases are tested on staging.", Contact: "#shop-team"},
+		{Projects: []string{"Big-*", "3f6c*"}, Action: "confirm"},
+		{Projects: []string{"legacy-shop"}, Action: "redirect", RedirectTo: "https://shop.example.com"},
+	})
+	assert.NoError(t, err)
+	svc := &Service{rules: rules}
+
+	shop := &oneko.Project{Uuid: "a1", Name: "shop"}
+	decision := svc.EvaluateRules(shop, &oneko.ProjectVersion{Name: "release-2.1"})
+	assert.Equal(t, RuleAllow, decision.Action)
+
+	decision = svc.EvaluateRules(shop, &oneko.ProjectVersion{Name: "release-candidate"})
+	assert.Equal(t, RuleDeny, decision.Action)
+	assert.False(t, decision.Allowed())
+	assert.Equal(t, "#shop-team", decision.Contact)
+
+	// versions without a matching rule are allowed
+	assert.Equal(t, RuleAllow, svc.EvaluateRules(shop, &oneko.ProjectVersion{Name: "feature-a"}).Action)
+
+	// glob patterns match the name or the uuid regardless of the case
+	decision = svc.EvaluateRules(&oneko.Project{Uuid: "a2", Name: "big-database"}, &oneko.ProjectVersion{Name: "main"})
+	assert.Equal(t, RuleConfirm, decision.Action)
+	assert.True(t, decision.Allowed())
+	assert.Equal(t, RuleConfirm, svc.EvaluateRules(&oneko.Project{Uuid: "3f6c0a", Name: "wiki"}, &oneko.ProjectVersion{Name: "main"}).Action)
+
+	decision = svc.EvaluateRules(&oneko.Project{Uuid: "a3", Name: "legacy-shop"}, &oneko.ProjectVersion{Name: "main"})
+	assert.Equal(t, RuleRedirect, decision.Action)
+	assert.False(t, decision.Allowed())
+	assert.Equal(t, "https://shop.example.com", decision.RedirectTo)
+}
+
+func Test_NewRules_RejectsInvalidPatterns(t *testing.T) {
+	_, err := newRules([]config.RuleConfig{{Versions: []string{"/release-(/"}, Action: "deny"}})
+	assert.Error(t, err)
+	_, err = newRules([]config.RuleConfig{{Projects: []string{"shop-["}, Action: "deny"}})
+	assert.Error(t, err)
+}
@@ -2,18 +2,38 @@ package service
 
 import (
 	"context"
-	"fmt"
 	"github.com/jellydator/ttlcache/v3"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
 	"log/slog"
+	"net"
+	"net/url"
 	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/i18n"
 	"o-neko-catnip/pkg/logger"
 	"o-neko-catnip/pkg/oneko"
 	"o-neko-catnip/pkg/oneko/api"
+	"o-neko-catnip/pkg/routing"
 	"o-neko-catnip/pkg/utils"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var protocolRegex = regexp.MustCompile("^https?://")
+
+// projectRefreshQueueSize limits the number of projects waiting to be loaded by the indexer.
+const projectRefreshQueueSize = 64
+
+// unknownUrlCacheCapacity and recentlyTriggeredCacheCapacity limit the memory used by the caches, URLs are sent by
+// clients, so there is no upper bound on the number of unknown ones.
+const (
+	unknownUrlCacheCapacity        = 10000
+	recentlyTriggeredCacheCapacity = 1000
 )
 
 type projectAndVersionIds struct {
@@ -22,158 +42,411 @@ type projectAndVersionIds struct {
 }
 
 type Service struct {
-	log                            *slog.Logger
-	projectIdToProjectCache        *ttlcache.Cache[string, *oneko.Project]
-	urlToProjectAndVersionIdsCache *ttlcache.Cache[string, projectAndVersionIds]
-	api                            *api.Api
+	log                        *slog.Logger
+	index                      atomic.Pointer[urlIndex]
+	projectCacheDuration       time.Duration
+	projectRefreshRequests     chan string
+	pendingProjectRefreshes    sync.Map
+	indexRefreshInterval       time.Duration
+	indexRefreshRequests       chan struct{}
+	indexFile                  string
+	hostMatcher                *routing.HostMatcher
+	rules                      []rule
+	api                        *api.Api
+	deployments                singleflight.Group
+	recentlyTriggeredCache     *ttlcache.Cache[string, bool]
+	deployGracePeriod          time.Duration
+	deduplicatedTriggerCounter *prometheus.CounterVec
+	unknownUrlCache            *ttlcache.Cache[string, bool]
+	unknownUrlCacheDuration    time.Duration
+	// only accessed by the indexer
+	lastIndexRebuild          time.Time
+	minIndexRebuildInterval   time.Duration
+	indexRebuildCounter       prometheus.Counter
+	unknownUrlHitCounter      prometheus.Counter
+	coalescedIndexLoadCounter prometheus.Counter
 }
 
 func New(configuration *config.Config, ctx context.Context) *Service {
 
+	startedAt := time.Now()
 	log := logger.New("onekoSvc")
 	onekoApi := api.New(configuration)
 
-	projectIdToProjectCache := ttlcache.New[string, *oneko.Project](
-		ttlcache.WithTTL[string, *oneko.Project](configuration.ONeko.Api.ApiCallCacheDuration),
-		ttlcache.WithDisableTouchOnHit[string, *oneko.Project](),
-		ttlcache.WithLoader[string, *oneko.Project](ttlcache.LoaderFunc[string, *oneko.Project](func(c *ttlcache.Cache[string, *oneko.Project], projectId string) *ttlcache.Item[string, *oneko.Project] {
-			log.Info("no cached entry found, calling o-neko api", slog.String("projectUuid", projectId))
-			project, err := onekoApi.GetProjectById(projectId, ctx)
-			if err != nil {
-				log.Error("O-Neko API returned an error", slog.Any("error", err))
-				return nil
-			}
-			entry := c.Set(projectId, project, ttlcache.DefaultTTL)
-			return entry
-		})),
+	hostMatcher, err := routing.NewHostMatcher(configuration.ONeko.Routing.HostPatterns)
+	if err != nil {
+		panic(err)
+	}
+
+	rules, err := newRules(configuration.ONeko.Rules)
+	if err != nil {
+		panic(err)
+	}
+
+	unknownUrlCache := ttlcache.New[string, bool](
+		ttlcache.WithTTL[string, bool](configuration.ONeko.Api.UnknownUrlCacheDuration),
+		ttlcache.WithDisableTouchOnHit[string, bool](),
+		ttlcache.WithCapacity[string, bool](unknownUrlCacheCapacity),
 	)
 
-	urlToProjectAndVersionIdsCache := ttlcache.New[string, projectAndVersionIds](
-		ttlcache.WithTTL[string, projectAndVersionIds](configuration.ONeko.Api.ApiCallCacheDuration),
-		ttlcache.WithDisableTouchOnHit[string, projectAndVersionIds](),
-		ttlcache.WithLoader[string, projectAndVersionIds](ttlcache.LoaderFunc[string, projectAndVersionIds](func(c *ttlcache.Cache[string, projectAndVersionIds], deploymentUrl string) *ttlcache.Item[string, projectAndVersionIds] {
-			deploymentUrl, err := getDeploymentUrlWithoutProtocolAndPath(deploymentUrl)
-			if err != nil {
-				return nil
-			}
-
-			entry, err := populateUrlToIdCacheAndReturnEntryForUrl(c, log, onekoApi, deploymentUrl)
-			if err != nil {
-				return nil
-			}
-			return entry
-		})),
+	recentlyTriggeredCache := ttlcache.New[string, bool](
+		ttlcache.WithDisableTouchOnHit[string, bool](),
+		ttlcache.WithCapacity[string, bool](recentlyTriggeredCacheCapacity),
 	)
 
+	// expired entries are only removed while the caches are started
+	go unknownUrlCache.Start()
+	go recentlyTriggeredCache.Start()
+	go func() {
+		<-ctx.Done()
+		unknownUrlCache.Stop()
+		recentlyTriggeredCache.Stop()
+	}()
+
+	onekoApi.StartConnectionMonitor(ctx)
+
+	service := &Service{
+		log:                    log,
+		projectCacheDuration:   configuration.ONeko.Api.ApiCallCacheDuration,
+		projectRefreshRequests: make(chan string, projectRefreshQueueSize),
+		indexRefreshInterval:   configuration.ONeko.Api.IndexRefreshInterval,
+		indexRefreshRequests:   make(chan struct{}, 1),
+		indexFile:              configuration.ONeko.Api.IndexFile,
+		hostMatcher:            hostMatcher,
+		rules:                  rules,
+		api:                    onekoApi,
+		recentlyTriggeredCache: recentlyTriggeredCache,
+		deployGracePeriod:      configuration.ONeko.Api.DeployGracePeriod,
+		deduplicatedTriggerCounter: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "oneko_catnip_deduplicated_triggers_total",
+			Help: "The number of deployment triggers not sent to O-Neko because the version was being or had just been triggered.",
+		}, []string{"reason"}),
+		unknownUrlCache:         unknownUrlCache,
+		unknownUrlCacheDuration: configuration.ONeko.Api.UnknownUrlCacheDuration,
+		minIndexRebuildInterval: configuration.ONeko.Api.MinIndexRebuildInterval,
+		indexRebuildCounter: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "oneko_catnip_index_rebuilds_total",
+			Help: "The number of times all projects have been loaded from O-Neko to index their URLs.",
+		}),
+		unknownUrlHitCounter: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "oneko_catnip_index_negative_hits_total",
+			Help: "The number of lookups of URLs answered from the cache of URLs not belonging to any project.",
+		}),
+		coalescedIndexLoadCounter: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "oneko_catnip_index_coalesced_loads_total",
+			Help: "The number of lookups of unindexed URLs joining an index rebuild which had already been requested.",
+		}),
+	}
+	service.index.Store(emptyUrlIndex)
+
 	promauto.NewGaugeFunc(prometheus.GaugeOpts{
 		Name: "oneko_catnip_cache_size",
 		Help: "The number of cached projects",
 	}, func() float64 {
-		return float64(len(projectIdToProjectCache.Keys()))
+		return float64(len(service.currentIndex().projects))
 	})
 
-	onekoApi.StartConnectionMonitor(ctx)
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "oneko_catnip_index_age_seconds",
+		Help: "The time since the URL index has been loaded from O-Neko, or since the start if it has never been loaded.",
+	}, func() float64 {
+		createdAt := service.currentIndex().createdAt
+		if createdAt.IsZero() {
+			createdAt = startedAt
+		}
+		return time.Since(createdAt).Seconds()
+	})
 
-	return &Service{
-		log:                            log,
-		projectIdToProjectCache:        projectIdToProjectCache,
-		urlToProjectAndVersionIdsCache: urlToProjectAndVersionIdsCache,
-		api:                            onekoApi,
+	if persisted := service.loadIndexFile(); persisted != nil {
+		// routing works right away, the indexer confirms the persisted projects in the background
+		service.index.Store(persisted)
+		service.requestIndexRefresh()
+	} else {
+		// the indexer loads the first index right away, starting catnip does not wait for O-Neko though
+		service.requestIndexRefresh()
 	}
+	go service.runIndexer(ctx)
+
+	return service
+}
+
+// MatchesHostPattern reports whether the host matches one of the configured host patterns. The port is ignored.
+func (o *Service) MatchesHostPattern(host string) bool {
+	return o.hostMatcher.Matches(GetHostWithoutPort(host))
+}
+
+// UrlMatch is the project version a URL belongs to together with the URL prefix (host and optional path without the
+// protocol) the URL matched. Stale matches use project data which has not been confirmed by O-Neko since catnip started.
+type UrlMatch struct {
+	Project   *oneko.Project
+	Version   *oneko.ProjectVersion
+	UrlPrefix string
+	Stale     bool
 }
 
 func (o *Service) GetProjectAndVersionForUrl(url string) (*oneko.Project, *oneko.ProjectVersion, error) {
-	fromCache := o.urlToProjectAndVersionIdsCache.Get(url)
-	if fromCache == nil {
-		return nil, nil, fmt.Errorf("no project found with url " + url)
-	} else {
-		value := fromCache.Value()
-		return o.GetProjectAndVersionByIds(value.project, value.projectVersion)
+	match, err := o.MatchUrl(url)
+	if err != nil {
+		return nil, nil, err
 	}
+	return match.Project, match.Version, nil
 }
 
-func (o *Service) getProjectById(projectId string) (*oneko.Project, error) {
-	fromCache := o.projectIdToProjectCache.Get(projectId)
-	if fromCache == nil {
-		return nil, fmt.Errorf("no project found with id " + projectId)
+// MatchUrl finds the project version with the longest URL prefix matching the url. Multiple versions may share a host
+// as long as their URLs have different paths.
+func (o *Service) MatchUrl(url string) (*UrlMatch, error) {
+	if match := o.matchUrlByHostPattern(url); match != nil {
+		return match, nil
+	}
+
+	prefix := GetDeploymentUrlPrefix(url)
+	matchedPrefix, ids, found := o.currentIndex().findLongestPrefix(prefix)
+	if !found {
+		o.handleUnknownPrefix(prefix)
+		return nil, i18n.Errorf("error.projectNotFoundByUrl", "no project found with url %s", url)
+	}
+
+	project, stale, err := o.getProjectById(ids.project)
+	if err != nil {
+		return nil, err
+	}
+	version := project.GetProjectVersionMatchingUuid(ids.projectVersion)
+	if version == nil {
+		return nil, i18n.Errorf("error.versionNotFound", "did not find version with id %s in project with id %s", ids.projectVersion, ids.project)
+	}
+	return &UrlMatch{
+		Project:   project,
+		Version:   version,
+		UrlPrefix: matchedPrefix,
+		Stale:     stale,
+	}, nil
+}
+
+// MatchDeploymentUrl matches an absolute URL received from a client. Only http(s) URLs without user info belonging to a
+// known project version are accepted, so the URL is safe to probe or redirect to.
+func (o *Service) MatchDeploymentUrl(rawUrl string) (*UrlMatch, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.User != nil || len(parsed.Host) == 0 {
+		return nil, i18n.Errorf("error.invalidDeploymentUrl", "%q is not a valid deployment url", rawUrl)
+	}
+	match, err := o.MatchUrl(parsed.Host + parsed.EscapedPath())
+	if err != nil {
+		return nil, i18n.Errorf("error.unknownDeploymentUrl", "%q does not belong to a known project version", rawUrl)
+	}
+	return match, nil
+}
+
+// matchUrlByHostPattern uses the values captured by a matching host pattern to look up the project and version
+// without indexing the URLs of all projects. It returns nil if the captures are not sufficient.
+func (o *Service) matchUrlByHostPattern(url string) *UrlMatch {
+	host, err := getDeploymentUrlWithoutProtocolAndPath(url)
+	if err != nil {
+		return nil
+	}
+
+	match, matches := o.hostMatcher.Match(GetHostWithoutPort(host))
+	if !matches {
+		return nil
+	}
+
+	projectId := match.ProjectId
+	if len(projectId) == 0 && len(match.ProjectName) > 0 {
+		projectId = o.currentIndex().projectIdsByName[strings.ToLower(match.ProjectName)]
+	}
+	if len(projectId) == 0 {
+		return nil
+	}
+
+	project, stale, err := o.getProjectById(projectId)
+	if err != nil {
+		return nil
+	}
+
+	var version *oneko.ProjectVersion
+	if len(match.VersionId) > 0 {
+		version = project.GetProjectVersionMatchingUuid(match.VersionId)
+	} else if len(match.VersionName) > 0 {
+		version = project.GetProjectVersionMatchingName(match.VersionName)
 	} else {
-		o.log.Info("serving project from cache", slog.String("projectId", projectId))
-		return fromCache.Value(), nil
+		version = project.GetProjectVersionMatchingUrl(url)
+	}
+	if version == nil {
+		return nil
+	}
+
+	o.log.Debug("found project version by host pattern", slog.String("host", host), slog.String("projectId", project.Uuid), slog.String("versionId", version.Uuid))
+	return &UrlMatch{
+		Project:   project,
+		Version:   version,
+		UrlPrefix: strings.ToLower(host),
+		Stale:     stale,
+	}
+}
+
+// getProjectById serves the project from the url index and never waits for O-Neko. Projects loaded longer than
+// ApiCallCacheDuration ago are refreshed in the background. It reports whether the project comes from the index file
+// and has not been confirmed by O-Neko yet.
+func (o *Service) getProjectById(projectId string) (*oneko.Project, bool, error) {
+	index := o.currentIndex()
+	project, ok := index.projects[projectId]
+	if !ok {
+		return nil, false, i18n.Errorf("error.projectNotFound", "no project found with id %s", projectId)
+	}
+	if time.Since(index.loadedAt(projectId)) > o.projectCacheDuration {
+		o.requestProjectRefresh(projectId)
 	}
+	return project, index.isStale(projectId), nil
 }
 
 func (o *Service) GetProjectAndVersionByIds(projectUuid, versionUuid string) (*oneko.Project, *oneko.ProjectVersion, error) {
-	project, err := o.getProjectById(projectUuid)
+	project, _, err := o.getProjectById(projectUuid)
 	if err != nil {
 		return nil, nil, err
 	}
 	version := project.GetProjectVersionMatchingUuid(versionUuid)
 	if version == nil {
-		return nil, nil, fmt.Errorf("did not find version with id %s in project with id %s", versionUuid, projectUuid)
+		return nil, nil, i18n.Errorf("error.versionNotFound", "did not find version with id %s in project with id %s", versionUuid, projectUuid)
 	}
 	return project, version, nil
 }
 
+// TriggerDeployment asks O-Neko to deploy the version. Concurrent triggers of the same version share a single call to
+// O-Neko and triggers within the grace period after a successful one are ignored, because the cached project still
+// reports the version as not deployed until O-Neko has picked it up. Versions denied by a rule are never triggered.
 func (o *Service) TriggerDeployment(projectId, versionId string, ctx context.Context) error {
+	if err := o.checkRules(projectId, versionId); err != nil {
+		o.log.Info("not triggering deployment", slog.String("projectId", projectId), slog.String("versionId", versionId), slog.Any("error", err))
+		return err
+	}
+
+	if o.isRecentlyTriggered(projectId, versionId) {
+		return nil
+	}
+
+	triggered := false
+	_, err, _ := o.deployments.Do(versionId, func() (interface{}, error) {
+		triggered = true
+		// a concurrent trigger may have finished between the check above and the start of this call
+		if o.isRecentlyTriggered(projectId, versionId) {
+			return nil, nil
+		}
+		// the call is shared with other requests, so it must not be cancelled when this one is
+		return nil, o.deploy(projectId, versionId, context.WithoutCancel(ctx))
+	})
+	if !triggered {
+		o.log.Debug("joined concurrent trigger of deployment", slog.String("projectId", projectId), slog.String("versionId", versionId))
+		o.deduplicatedTriggerCounter.WithLabelValues("concurrent").Inc()
+	}
+	return err
+}
+
+func (o *Service) isRecentlyTriggered(projectId, versionId string) bool {
+	if o.recentlyTriggeredCache.Get(versionId) == nil {
+		return false
+	}
+	o.log.Debug("ignoring trigger of recently triggered deployment", slog.String("projectId", projectId), slog.String("versionId", versionId))
+	o.deduplicatedTriggerCounter.WithLabelValues("recently_triggered").Inc()
+	return true
+}
+
+// StopDeployment asks O-Neko to stop the deployment of the version.
+func (o *Service) StopDeployment(projectId, versionId string, ctx context.Context) error {
+	o.log.Debug("stopping deployment", slog.String("projectId", projectId), slog.String("versionId", versionId))
+	err := o.api.Stop(projectId, versionId, ctx)
+	if err != nil {
+		o.log.Info("encountered an error while stopping a deployment", slog.String("projectId", projectId), slog.String("versionId", versionId), slog.Any("error", err))
+	} else {
+		o.log.Info("stopped deployment", slog.String("projectId", projectId), slog.String("versionId", versionId))
+		// the version may be woken up again right away
+		o.recentlyTriggeredCache.Delete(versionId)
+	}
+	o.requestProjectRefresh(projectId)
+	return err
+}
+
+func (o *Service) deploy(projectId, versionId string, ctx context.Context) error {
 	o.log.Debug("triggering deployment", slog.String("projectId", projectId), slog.String("versionId", versionId))
 	err := o.api.Deploy(projectId, versionId, ctx)
 	if err != nil {
 		o.log.Info("encountered an error while triggering a deployment", slog.String("projectId", projectId), slog.String("versionId", versionId), slog.Any("error", err))
 	} else {
 		o.log.Info("triggered deployment", slog.String("projectId", projectId), slog.String("versionId", versionId))
+		if o.deployGracePeriod > 0 {
+			o.recentlyTriggeredCache.Set(versionId, true, o.deployGracePeriod)
+		}
 	}
-	o.projectIdToProjectCache.Delete(projectId)
+	o.requestProjectRefresh(projectId)
 	return err
 }
 
-func (o *Service) GetAllProjectDomains() *utils.Set[string] {
-	err := o.ensureUrlToIdCacheIsPopulated()
+func (o *Service) loadIndexFile() *urlIndex {
+	if len(o.indexFile) == 0 {
+		return nil
+	}
+	index, err := readIndexFile(o.indexFile)
 	if err != nil {
-		o.log.Info("encountered an error populating the url cache", slog.Any("error", err))
+		o.log.Warn("failed to read the persisted url index", slog.String("file", o.indexFile), slog.Any("error", err))
+		return nil
 	}
-
-	set := utils.NewSet[string]()
-	set.AddAll(o.urlToProjectAndVersionIdsCache.Keys())
-	return set
+	if index != nil {
+		o.log.Info("loaded the persisted url index", slog.String("file", o.indexFile), slog.Time("createdAt", index.createdAt), slog.Int("projects", len(index.projects)))
+	}
+	return index
 }
 
-func (o *Service) ensureUrlToIdCacheIsPopulated() error {
-	if o.urlToProjectAndVersionIdsCache.Len() == 0 {
-		return o.populateUrlToIdCache()
+// GetAllProjects returns the projects of the url index sorted by name. It never waits for O-Neko.
+func (o *Service) GetAllProjects() []*oneko.Project {
+	index := o.currentIndex()
+	projects := make([]*oneko.Project, 0, len(index.projects))
+	for _, project := range index.projects {
+		projects = append(projects, project)
 	}
-	return nil
+	slices.SortFunc(projects, func(a, b *oneko.Project) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return projects
 }
 
-func (o *Service) populateUrlToIdCache() error {
-	_, err := populateUrlToIdCacheAndReturnEntryForUrl(o.urlToProjectAndVersionIdsCache, o.log, o.api, "")
-	return err
+// GetAllProjectDomains returns the hosts of all project versions. It never waits for O-Neko.
+func (o *Service) GetAllProjectDomains() *utils.Set[string] {
+	return o.currentIndex().domains
 }
 
-func populateUrlToIdCacheAndReturnEntryForUrl(cache *ttlcache.Cache[string, projectAndVersionIds], log *slog.Logger, onekoApi *api.Api, deploymentUrl string) (*ttlcache.Item[string, projectAndVersionIds], error) {
-	projects, err := onekoApi.GetAllProjects(context.Background())
-	if err != nil {
-		log.Error("O-Neko API returned an error", slog.Any("error", err))
-		return nil, err
+// handleUnknownPrefix requests an index refresh to find URLs of projects created since the last one. Prefixes are
+// remembered, so requests for unknown hosts do not cause a refresh each.
+func (o *Service) handleUnknownPrefix(prefix string) {
+	if o.unknownUrlCache.Get(prefix) != nil {
+		o.unknownUrlHitCounter.Inc()
+		return
 	}
-	var searchEntry *ttlcache.Item[string, projectAndVersionIds]
-	for _, project := range projects {
-		for _, version := range project.Versions {
-			for _, url := range version.Urls {
-				entry := projectAndVersionIds{
-					project:        project.Uuid,
-					projectVersion: version.Uuid,
-				}
-				urlWithoutProtocolAndPath, err2 := getDeploymentUrlWithoutProtocolAndPath(url)
-				if err2 == nil {
-					cacheEntry := cache.Set(urlWithoutProtocolAndPath, entry, ttlcache.DefaultTTL)
-					if strings.EqualFold(deploymentUrl, urlWithoutProtocolAndPath) {
-						searchEntry = cacheEntry
-					}
-				}
-			}
-		}
+	// all urls are unknown until the first index has been loaded
+	if o.unknownUrlCacheDuration > 0 && o.currentIndex() != emptyUrlIndex {
+		o.unknownUrlCache.Set(prefix, true, ttlcache.DefaultTTL)
+	}
+	o.requestIndexRefresh()
+}
+
+// GetDeploymentUrlPrefix strips the protocol, query, fragment and trailing slashes off the url and lowercases the
+// host, e.g. https://Preview.example.com/shop-a/?tab=1 becomes preview.example.com/shop-a
+func GetDeploymentUrlPrefix(deploymentUrl string) string {
+	withoutProtocol := protocolRegex.ReplaceAllString(deploymentUrl, "")
+	if end := strings.IndexAny(withoutProtocol, "?#"); end >= 0 {
+		withoutProtocol = withoutProtocol[:end]
+	}
+	host, path, _ := strings.Cut(withoutProtocol, "/")
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(host) + "/" + path
+}
+
+// GetHostWithoutPort lowercases the host and strips its port, e.g. Shop.Example.com:8443 becomes shop.example.com
+func GetHostWithoutPort(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
 	}
-	return searchEntry, nil
+	return strings.ToLower(host)
 }
 
 func getDeploymentUrlWithoutProtocolAndPath(deploymentUrl string) (string, error) {
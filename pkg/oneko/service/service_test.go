@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	uut                *Service
+	deployCalls        atomic.Int32
+	getAllProjectCalls atomic.Int32
+	getProjectCalls    atomic.Int32
+	onekoDown          atomic.Bool
+	blogDeployed       atomic.Bool
+)
+
+// projectsJson renders the projects known to the fake O-Neko, the blog draft is deployed once it has been triggered.
+func projectsJson() (string, string) {
+	desiredState := "NotDeployed"
+	if blogDeployed.Load() {
+		desiredState = "Deployed"
+	}
+	shop := `{"uuid":"project","name":"shop","versions":[{"uuid":"version","name":"main","urls":["https://shop.example.com","https://main.preview.example.com"]}]}`
+	blog := fmt.Sprintf(`{"uuid":"blog","name":"blog","versions":[{"uuid":"draft","name":"draft","urls":["https://blog.example.com"],"desiredState":"%s"}]}`, desiredState)
+	return "[" + shop + "," + blog + "]", blog
+}
+
+func TestMain(m *testing.M) {
+	onekoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/deploy") {
+			deployCalls.Add(1)
+			if strings.HasPrefix(r.URL.Path, "/api/project/blog/") {
+				blogDeployed.Store(true)
+			}
+			// give concurrent triggers the chance to pile up
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.URL.Path == "/api/project" {
+			getAllProjectCalls.Add(1)
+			if onekoDown.Load() {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			all, _ := projectsJson()
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(all))
+			return
+		}
+		if r.URL.Path == "/api/project/blog" {
+			getProjectCalls.Add(1)
+			// slower than any lookup may take
+			time.Sleep(200 * time.Millisecond)
+			_, blog := projectsJson()
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(blog))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	config.OverrideConfiguration(&config.Config{
+		ONeko: config.ONekoConfig{
+			Api: config.ApiConfig{
+				BaseUrl: onekoServer.URL,
+				Auth: config.AuthConfig{
+					Username: "admin",
+					Password: "s3cr3t",
+				},
+				ApiCallCacheDuration:    15 * time.Second,
+				DeployGracePeriod:       time.Minute,
+				UnknownUrlCacheDuration: time.Minute,
+				IndexRefreshInterval:    time.Minute,
+			},
+			Routing: config.RoutingConfig{
+				HostPatterns: []config.HostPatternConfig{
+					{Glob: "*.preview.example.com"},
+					{Regex: `(?P<versionName>[a-z]+)--(?P<projectName>[a-z]+)\.apps\.example\.com`},
+				},
+			},
+			Mode: "production",
+			Logging: config.LoggingConfig{
+				Level: "error",
+			},
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	uut = New(config.Configuration(), ctx)
+	// the first index is loaded in the background
+	for deadline := time.Now().Add(5 * time.Second); uut.currentIndex() == emptyUrlIndex && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	code := m.Run()
+
+	cancel()
+	onekoServer.Close()
+	os.Exit(code)
+}
+
+func Test_GetDeploymentUrlPrefix(t *testing.T) {
+	assert.Equal(t, "preview.example.com", GetDeploymentUrlPrefix("https://Preview.Example.com"))
+	assert.Equal(t, "preview.example.com", GetDeploymentUrlPrefix("preview.example.com/"))
+	assert.Equal(t, "preview.example.com", GetDeploymentUrlPrefix("http://preview.example.com?tab=checkout"))
+	assert.Equal(t, "preview.example.com/shop-a", GetDeploymentUrlPrefix("https://preview.example.com/shop-a/"))
+	assert.Equal(t, "preview.example.com/shop-a/cart", GetDeploymentUrlPrefix("preview.example.com/shop-a/cart?tab=checkout#top"))
+	assert.Equal(t, "preview.example.com:8080/Shop-A", GetDeploymentUrlPrefix("http://preview.example.com:8080/Shop-A"))
+}
+
+func Test_TriggerDeployment_DeduplicatesTriggers(t *testing.T) {
+	deployCallsBefore := deployCalls.Load()
+	concurrentBefore := testutil.ToFloat64(uut.deduplicatedTriggerCounter.WithLabelValues("concurrent"))
+	recentBefore := testutil.ToFloat64(uut.deduplicatedTriggerCounter.WithLabelValues("recently_triggered"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, uut.TriggerDeployment("project", "version", context.Background()))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, deployCallsBefore+1, deployCalls.Load())
+
+	// triggers within the grace period are ignored as well
+	assert.NoError(t, uut.TriggerDeployment("project", "version", context.Background()))
+	assert.Equal(t, deployCallsBefore+1, deployCalls.Load())
+
+	deduplicated := testutil.ToFloat64(uut.deduplicatedTriggerCounter.WithLabelValues("concurrent")) - concurrentBefore +
+		testutil.ToFloat64(uut.deduplicatedTriggerCounter.WithLabelValues("recently_triggered")) - recentBefore
+	assert.Equal(t, float64(10), deduplicated)
+
+	// other versions are not affected
+	assert.NoError(t, uut.TriggerDeployment("project", "other-version", context.Background()))
+	assert.Equal(t, deployCallsBefore+2, deployCalls.Load())
+}
+
+func Test_MatchUrl_ProtectsTheApiFromUnknownUrls(t *testing.T) {
+	callsBefore := getAllProjectCalls.Load()
+	negativeHitsBefore := testutil.ToFloat64(uut.unknownUrlHitCounter)
+
+	// lookups never wait for O-Neko, unknown urls only request a refresh of the index in the background
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := uut.MatchUrl("unknown.example.com/wp-admin")
+			assert.Error(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Eventually(t, func() bool {
+		return getAllProjectCalls.Load() > callsBefore
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the unknown url is remembered
+	negativeHitsBefore = testutil.ToFloat64(uut.unknownUrlHitCounter)
+	_, err := uut.MatchUrl("unknown.example.com/wp-admin")
+	assert.Error(t, err)
+	assert.Equal(t, negativeHitsBefore+1, testutil.ToFloat64(uut.unknownUrlHitCounter))
+
+	// requests for other unknown urls are coalesced into at most one more refresh
+	for i := 0; i < 10; i++ {
+		_, err = uut.MatchUrl(fmt.Sprintf("typo-%d.example.com", i))
+		assert.Error(t, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	assert.LessOrEqual(t, getAllProjectCalls.Load(), callsBefore+4)
+}
+
+func Test_Caches_AreLimited(t *testing.T) {
+	t.Cleanup(func() {
+		uut.unknownUrlCache.DeleteAll()
+		uut.recentlyTriggeredCache.DeleteAll()
+	})
+
+	// the urls are sent by clients, e.g. random hosts matching a host pattern
+	for i := 0; i < unknownUrlCacheCapacity+100; i++ {
+		uut.unknownUrlCache.Set(fmt.Sprintf("random-%d.example.com", i), true, ttlcache.DefaultTTL)
+	}
+	for i := 0; i < recentlyTriggeredCacheCapacity+100; i++ {
+		uut.recentlyTriggeredCache.Set(fmt.Sprintf("version-%d", i), true, time.Minute)
+	}
+
+	assert.Equal(t, unknownUrlCacheCapacity, uut.unknownUrlCache.Len())
+	assert.Equal(t, recentlyTriggeredCacheCapacity, uut.recentlyTriggeredCache.Len())
+}
+
+func Test_MatchUrl_DoesNotRememberUnknownUrlsBeforeTheFirstIndexHasBeenLoaded(t *testing.T) {
+	uut.index.Store(emptyUrlIndex)
+
+	_, err := uut.MatchUrl("shop.example.com/cart")
+
+	assert.Error(t, err)
+	assert.Nil(t, uut.unknownUrlCache.Get("shop.example.com/cart"))
+	assert.Eventually(t, func() bool {
+		_, err := uut.MatchUrl("shop.example.com/cart")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_MatchUrl_UsesTheIndex(t *testing.T) {
+	match, err := uut.MatchUrl("shop.example.com/cart")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "project", match.Project.Uuid)
+		assert.Equal(t, "version", match.Version.Uuid)
+		assert.Equal(t, "shop.example.com", match.UrlPrefix)
+	}
+	assert.True(t, uut.GetAllProjectDomains().Contains("shop.example.com"))
+}
+
+func Test_RefreshIndex_KeepsTheLastIndexWhileONekoIsDown(t *testing.T) {
+	onekoDown.Store(true)
+	defer onekoDown.Store(false)
+	indexBefore := uut.currentIndex()
+
+	assert.Error(t, uut.refreshIndex(context.Background()))
+
+	assert.Same(t, indexBefore, uut.currentIndex())
+	_, err := uut.MatchUrl("shop.example.com")
+	assert.NoError(t, err)
+}
+
+func Test_MatchUrl_IgnoresPortsTheUrlsDoNotHave(t *testing.T) {
+	match, err := uut.MatchUrl("Shop.Example.com:443/cart")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "version", match.Version.Uuid)
+		assert.Equal(t, "shop.example.com", match.UrlPrefix)
+	}
+	assert.Equal(t, "shop.example.com", GetHostWithoutPort("Shop.Example.com:443"))
+	assert.Equal(t, "shop.example.com", GetHostWithoutPort("shop.example.com"))
+}
+
+func Test_MatchUrl_NeverWaitsForONekoToLoadTheProject(t *testing.T) {
+	match, err := uut.MatchUrl("blog.example.com")
+	if assert.NoError(t, err) {
+		assert.Equal(t, oneko.NotDeployed, match.Version.DesiredState)
+	}
+	getProjectCallsBefore := getProjectCalls.Load()
+
+	assert.NoError(t, uut.TriggerDeployment("blog", "draft", context.Background()))
+
+	// the project is loaded again in the background, lookups keep serving the indexed one meanwhile
+	startedAt := time.Now()
+	match, err = uut.MatchUrl("blog.example.com")
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(startedAt), 200*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		match, err := uut.MatchUrl("blog.example.com")
+		return err == nil && match.Version.DesiredState == oneko.Deployed
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, getProjectCallsBefore+1, getProjectCalls.Load())
+}
+
+func Test_MatchUrl_UsesTheCapturesOfHostPatterns(t *testing.T) {
+	match, err := uut.MatchUrl("Main--Shop.apps.example.com:8443/cart")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "project", match.Project.Uuid)
+		assert.Equal(t, "version", match.Version.Uuid)
+		assert.Equal(t, "main--shop.apps.example.com:8443", match.UrlPrefix)
+	}
+	match, err = uut.MatchUrl("draft--blog.apps.example.com")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "draft", match.Version.Uuid)
+	}
+
+	// hosts of unknown projects and versions are not matched, even though they match the pattern
+	assert.True(t, uut.MatchesHostPattern("main--blog.apps.example.com"))
+	_, err = uut.MatchUrl("main--blog.apps.example.com")
+	assert.Error(t, err)
+	_, err = uut.MatchUrl("main--wiki.apps.example.com")
+	assert.Error(t, err)
+}
+
+func Test_MatchUrl_LooksUpHostsMatchingWildcardsInTheIndex(t *testing.T) {
+	// globs capture nothing, so the host must belong to a version
+	assert.True(t, uut.MatchesHostPattern("Main.Preview.example.com:443"))
+	match, err := uut.MatchUrl("main.preview.example.com/cart")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "version", match.Version.Uuid)
+		assert.Equal(t, "main.preview.example.com", match.UrlPrefix)
+	}
+
+	assert.True(t, uut.MatchesHostPattern("feature.preview.example.com"))
+	_, err = uut.MatchUrl("feature.preview.example.com")
+	assert.Error(t, err)
+	assert.False(t, uut.MatchesHostPattern("preview.example.com.evil.com"))
+}
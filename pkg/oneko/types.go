@@ -1,6 +1,7 @@
 package oneko
 
 import (
+	"path"
 	"regexp"
 	"strings"
 	"time"
@@ -70,6 +71,27 @@ func (p Project) GetProjectVersionMatchingUuid(versionUuid string) *ProjectVersi
 	return nil
 }
 
+func (p Project) GetProjectVersionMatchingName(versionName string) *ProjectVersion {
+	for _, version := range p.Versions {
+		if strings.EqualFold(versionName, version.Name) {
+			return &version
+		}
+	}
+	return nil
+}
+
+// MatchesAny reports whether the name or the UUID of the project matches one of the glob patterns.
+func (p Project) MatchesAny(patterns []string) bool {
+	for _, pattern := range patterns {
+		for _, value := range []string{p.Name, p.Uuid} {
+			if matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(value)); err == nil && matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (v ProjectVersion) IsDeployed() bool {
 	return v.DesiredState == Deployed
 }
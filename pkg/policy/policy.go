@@ -0,0 +1,176 @@
+package policy
+
+import (
+	"fmt"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"time"
+
+	// the container image has no time zone database
+	_ "time/tzdata"
+)
+
+// Policy decides whether deployments may be woken up at the current time.
+type Policy struct {
+	defaults  *schedule
+	overrides []projectSchedule
+	clock     utils.Clock
+}
+
+// Decision is the outcome of a policy check. NextAllowed and RetryAfter, the time from the check until then, are only set
+// if the wake-up is not allowed.
+type Decision struct {
+	Allowed     bool
+	NextAllowed time.Time
+	RetryAfter  time.Duration
+}
+
+type schedule struct {
+	location *time.Location
+	windows  []window
+}
+
+type projectSchedule struct {
+	projects []string
+	schedule *schedule
+}
+
+type window struct {
+	weekdays map[time.Weekday]bool
+	from     time.Duration
+	to       time.Duration
+}
+
+func New(policyConfig config.PolicyConfig) (*Policy, error) {
+	return newWithClock(policyConfig, utils.NewClock())
+}
+
+func newWithClock(policyConfig config.PolicyConfig, clock utils.Clock) (*Policy, error) {
+	defaults, err := newSchedule(policyConfig.TimeZone, policyConfig.Windows)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{
+		defaults: defaults,
+		clock:    clock,
+	}
+	for _, override := range policyConfig.Projects {
+		timeZone := override.TimeZone
+		if len(timeZone) == 0 {
+			timeZone = policyConfig.TimeZone
+		}
+		overrideSchedule, err := newSchedule(timeZone, override.Windows)
+		if err != nil {
+			return nil, fmt.Errorf("policy of projects %v: %w", override.Projects, err)
+		}
+		p.overrides = append(p.overrides, projectSchedule{
+			projects: override.Projects,
+			schedule: overrideSchedule,
+		})
+	}
+	return p, nil
+}
+
+func newSchedule(timeZone string, windowConfigs []config.WakeupWindowConfig) (*schedule, error) {
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", timeZone, err)
+	}
+	s := &schedule{location: location}
+	for _, windowConfig := range windowConfigs {
+		w, err := newWindow(windowConfig)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+func newWindow(windowConfig config.WakeupWindowConfig) (window, error) {
+	w := window{weekdays: make(map[time.Weekday]bool)}
+	for _, name := range windowConfig.Weekdays {
+		day, ok := config.ParseWeekday(name)
+		if !ok {
+			return w, fmt.Errorf("invalid weekday %q", name)
+		}
+		w.weekdays[day] = true
+	}
+	if len(w.weekdays) == 0 {
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			w.weekdays[day] = true
+		}
+	}
+	var err error
+	if w.from, err = parseTimeOfDay(windowConfig.From); err != nil {
+		return w, err
+	}
+	if w.to, err = parseTimeOfDay(windowConfig.To); err != nil {
+		return w, err
+	}
+	if w.to <= w.from {
+		w.to += 24 * time.Hour
+	}
+	return w, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: %w", value, err)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// Check returns whether the project may be woken up now and otherwise when it may be woken up next.
+func (p *Policy) Check(project *oneko.Project) Decision {
+	now := p.clock.Now()
+	decision := p.scheduleOf(project).check(now)
+	if !decision.Allowed {
+		decision.RetryAfter = decision.NextAllowed.Sub(now)
+	}
+	return decision
+}
+
+func (p *Policy) scheduleOf(project *oneko.Project) *schedule {
+	for _, override := range p.overrides {
+		if project.MatchesAny(override.projects) {
+			return override.schedule
+		}
+	}
+	return p.defaults
+}
+
+func (s *schedule) check(now time.Time) Decision {
+	if len(s.windows) == 0 {
+		return Decision{Allowed: true}
+	}
+	now = now.In(s.location)
+	var next time.Time
+	// windows starting on the previous day may still be open, the next window starts within a week at the latest
+	for offset := -1; offset <= 7; offset++ {
+		day := time.Date(now.Year(), now.Month(), now.Day()+offset, 0, 0, 0, 0, s.location)
+		for _, w := range s.windows {
+			if !w.weekdays[day.Weekday()] {
+				continue
+			}
+			start := atTimeOfDay(day, w.from)
+			end := atTimeOfDay(day, w.to)
+			if !now.Before(start) && now.Before(end) {
+				return Decision{Allowed: true}
+			}
+			if start.After(now) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return Decision{Allowed: false, NextAllowed: next}
+}
+
+// atTimeOfDay uses the wall clock time on the given day, so windows keep their local times across DST changes.
+func atTimeOfDay(day time.Time, timeOfDay time.Duration) time.Time {
+	hours := int(timeOfDay / time.Hour)
+	minutes := int((timeOfDay % time.Hour) / time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day(), hours, minutes, 0, 0, day.Location())
+}
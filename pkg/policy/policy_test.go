@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var officeHours = config.WakeupWindowConfig{
+	Weekdays: []string{"monday", "Tue", "wednesday", "thursday", "friday"},
+	From:     "07:00",
+	To:       "20:00",
+}
+
+func berlin(t *testing.T, value string) time.Time {
+	location, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+	assert.NoError(t, err)
+	return parsed
+}
+
+func Test_Check_AllowsEverythingWithoutWindows(t *testing.T) {
+	p, err := newWithClock(config.PolicyConfig{}, utils.NewTimeMachineAt(berlin(t, "2024-03-09 02:00")))
+	assert.NoError(t, err)
+	assert.True(t, p.Check(&oneko.Project{Name: "shop"}).Allowed)
+}
+
+func Test_Check_Windows(t *testing.T) {
+	policyConfig := config.PolicyConfig{
+		TimeZone: "Europe/Berlin",
+		Windows:  []config.WakeupWindowConfig{officeHours},
+	}
+	tests := []struct {
+		name        string
+		now         string
+		allowed     bool
+		nextAllowed string
+	}{
+		{"within the window", "2024-03-06 12:00", true, ""},
+		{"at the start of the window", "2024-03-06 07:00", true, ""},
+		{"at the end of the window", "2024-03-06 20:00", false, "2024-03-07 07:00"},
+		{"early in the morning", "2024-03-06 02:00", false, "2024-03-06 07:00"},
+		{"friday night", "2024-03-08 23:00", false, "2024-03-11 07:00"},
+		{"on the weekend", "2024-03-09 12:00", false, "2024-03-11 07:00"},
+		{"after a DST change", "2024-03-31 12:00", false, "2024-04-01 07:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newWithClock(policyConfig, utils.NewTimeMachineAt(berlin(t, tt.now)))
+			assert.NoError(t, err)
+			decision := p.Check(&oneko.Project{Name: "shop"})
+			assert.Equal(t, tt.allowed, decision.Allowed)
+			if !tt.allowed {
+				assert.True(t, berlin(t, tt.nextAllowed).Equal(decision.NextAllowed), "expected %s, got %s", tt.nextAllowed, decision.NextAllowed)
+				assert.Equal(t, berlin(t, tt.nextAllowed).Sub(berlin(t, tt.now)), decision.RetryAfter)
+			}
+		})
+	}
+}
+
+func Test_Check_WindowsAcrossMidnight(t *testing.T) {
+	policyConfig := config.PolicyConfig{
+		TimeZone: "Europe/Berlin",
+		Windows: []config.WakeupWindowConfig{{
+			Weekdays: []string{"friday"},
+			From:     "22:00",
+			To:       "02:00",
+		}},
+	}
+	tm := utils.NewTimeMachineAt(berlin(t, "2024-03-09 01:00"))
+	p, err := newWithClock(policyConfig, tm)
+	assert.NoError(t, err)
+	assert.True(t, p.Check(&oneko.Project{Name: "shop"}).Allowed)
+
+	tm.TimeTravel(2 * time.Hour)
+	decision := p.Check(&oneko.Project{Name: "shop"})
+	assert.False(t, decision.Allowed)
+	assert.True(t, berlin(t, "2024-03-15 22:00").Equal(decision.NextAllowed))
+}
+
+func Test_Check_ProjectOverrides(t *testing.T) {
+	policyConfig := config.PolicyConfig{
+		TimeZone: "Europe/Berlin",
+		Windows:  []config.WakeupWindowConfig{officeHours},
+		Projects: []config.ProjectPolicyConfig{
+			{
+				Projects: []string{"nightly-*"},
+			},
+			{
+				Projects: []string{"support"},
+				TimeZone: "America/New_York",
+				Windows:  []config.WakeupWindowConfig{officeHours},
+			},
+		},
+	}
+	// 02:00 in Berlin is 20:00 of the previous day in New York
+	p, err := newWithClock(policyConfig, utils.NewTimeMachineAt(berlin(t, "2024-03-06 02:00")))
+	assert.NoError(t, err)
+
+	assert.False(t, p.Check(&oneko.Project{Name: "shop"}).Allowed)
+	assert.True(t, p.Check(&oneko.Project{Name: "nightly-tests"}).Allowed)
+	decision := p.Check(&oneko.Project{Name: "support"})
+	assert.False(t, decision.Allowed)
+	assert.True(t, berlin(t, "2024-03-06 13:00").Equal(decision.NextAllowed))
+}
+
+func Test_New_RejectsInvalidWindows(t *testing.T) {
+	_, err := New(config.PolicyConfig{
+		Windows: []config.WakeupWindowConfig{{Weekdays: []string{"caturday"}, From: "07:00", To: "20:00"}},
+	})
+	assert.Error(t, err)
+}
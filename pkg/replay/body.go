@@ -0,0 +1,87 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+var ErrBodyTooLarge = errors.New("request body exceeds the configured maximum size")
+
+// Body holds a buffered request body so it can be sent again once the deployment is ready.
+// Small bodies are kept in memory, larger ones are spilled to a temporary file.
+type Body struct {
+	memory []byte
+	file   *os.File
+	size   int64
+}
+
+func NewBody(r io.Reader, maxMemorySize, maxSize int64, spoolDirectory string) (*Body, error) {
+	if maxMemorySize > maxSize {
+		maxMemorySize = maxSize
+	}
+
+	memory, err := io.ReadAll(io.LimitReader(r, maxMemorySize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(memory)) <= maxMemorySize {
+		return &Body{
+			memory: memory,
+			size:   int64(len(memory)),
+		}, nil
+	}
+
+	if int64(len(memory)) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+
+	file, err := os.CreateTemp(spoolDirectory, "catnip-replay-*")
+	if err != nil {
+		return nil, err
+	}
+	body := &Body{
+		file: file,
+	}
+
+	written, err := io.Copy(file, io.MultiReader(bytes.NewReader(memory), io.LimitReader(r, maxSize-int64(len(memory))+1)))
+	if err != nil {
+		_ = body.Close()
+		return nil, err
+	}
+	if written > maxSize {
+		_ = body.Close()
+		return nil, ErrBodyTooLarge
+	}
+	body.size = written
+	return body, nil
+}
+
+func (b *Body) Size() int64 {
+	return b.size
+}
+
+// Reader returns a new reader starting at the beginning of the body each time it is called.
+func (b *Body) Reader() io.ReadCloser {
+	if b.file != nil {
+		return io.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+	}
+	return io.NopCloser(bytes.NewReader(b.memory))
+}
+
+// Close releases the memory and removes the temporary file if the body has been spilled to disk.
+func (b *Body) Close() error {
+	b.memory = nil
+	if b.file == nil {
+		return nil
+	}
+	name := b.file.Name()
+	closeErr := b.file.Close()
+	b.file = nil
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,79 @@
+package replay
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Body_KeepsSmallBodiesInMemory(t *testing.T) {
+	dir := t.TempDir()
+
+	body, err := NewBody(strings.NewReader("hello"), 10, 100, dir)
+	assert.NoError(t, err)
+	defer body.Close()
+
+	assert.Nil(t, body.file)
+	assert.Equal(t, int64(5), body.Size())
+	assertBodyContent(t, body, "hello")
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_Body_SpillsLargeBodiesToDisk(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("catnip", 10)
+
+	body, err := NewBody(strings.NewReader(content), 10, 100, dir)
+	assert.NoError(t, err)
+
+	assert.NotNil(t, body.file)
+	assert.Equal(t, int64(len(content)), body.Size())
+	assertBodyContent(t, body, content)
+	// the body can be read multiple times
+	assertBodyContent(t, body, content)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.NoError(t, body.Close())
+	entries, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_Body_RejectsBodiesExceedingTheMaximumSize(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewBody(strings.NewReader(strings.Repeat("a", 101)), 10, 100, dir)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	_, err = NewBody(strings.NewReader(strings.Repeat("a", 11)), 10, 10, dir)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_Body_AcceptsBodiesOfExactlyTheMaximumSize(t *testing.T) {
+	body, err := NewBody(strings.NewReader(strings.Repeat("a", 100)), 10, 100, t.TempDir())
+	assert.NoError(t, err)
+	defer body.Close()
+
+	assert.Equal(t, int64(100), body.Size())
+}
+
+func assertBodyContent(t *testing.T, body *Body, expected string) {
+	reader := body.Reader()
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(content))
+}
@@ -0,0 +1,90 @@
+package replay
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"o-neko-catnip/pkg/logger"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// hopByHopHeaders are only meaningful for a single connection and must not be forwarded.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type Replayer struct {
+	client          *http.Client
+	log             *slog.Logger
+	replayedCounter *prometheus.CounterVec
+}
+
+func New() *Replayer {
+	return &Replayer{
+		client: &http.Client{
+			// redirects are handed back to the original caller
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		log: logger.New("replayer"),
+		replayedCounter: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "oneko_catnip_replayed_requests_total",
+			Help: "The number of buffered requests replayed to a deployment.",
+		}, []string{"success"}),
+	}
+}
+
+// Replay sends the original request with the buffered body to the target url and writes the response of the
+// deployment to w. If an error is returned nothing has been written to w yet.
+func (r *Replayer) Replay(ctx context.Context, w http.ResponseWriter, original *http.Request, targetUrl string, body *Body) error {
+	request, err := http.NewRequestWithContext(ctx, original.Method, targetUrl, body.Reader())
+	if err != nil {
+		r.replayedCounter.WithLabelValues("false").Inc()
+		return err
+	}
+	request.Header = original.Header.Clone()
+	removeHopByHopHeaders(request.Header)
+	request.Host = original.Host
+	request.ContentLength = body.Size()
+
+	r.log.Debug("replaying request", slog.String("method", original.Method), slog.String("url", targetUrl), slog.Int64("bodySize", body.Size()))
+	response, err := r.client.Do(request)
+	if err != nil {
+		r.replayedCounter.WithLabelValues("false").Inc()
+		return err
+	}
+	defer response.Body.Close()
+	r.replayedCounter.WithLabelValues("true").Inc()
+
+	header := w.Header()
+	for key, values := range response.Header {
+		header[key] = values
+	}
+	removeHopByHopHeaders(header)
+	if response.ContentLength >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(response.ContentLength, 10))
+	}
+	w.WriteHeader(response.StatusCode)
+	if _, err := io.Copy(w, response.Body); err != nil {
+		r.log.Info("failed to copy the replayed response to the caller", slog.String("url", targetUrl), slog.Any("error", err))
+	}
+	return nil
+}
+
+func removeHopByHopHeaders(header http.Header) {
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
@@ -0,0 +1,103 @@
+package replay
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"o-neko-catnip/pkg/config"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var uut *Replayer
+
+func TestMain(m *testing.M) {
+	config.OverrideConfiguration(&config.Config{
+		ONeko: config.ONekoConfig{
+			Mode: "production",
+			Logging: config.LoggingConfig{
+				Level: "error",
+			},
+		},
+	})
+	uut = New()
+	os.Exit(m.Run())
+}
+
+func Test_Replay_SendsTheOriginalRequestAndCopiesTheResponse(t *testing.T) {
+	var received *http.Request
+	var receivedBody string
+	deployment := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		received, receivedBody = r, string(content)
+		w.Header().Set("X-Order", "42")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"order":42}`))
+	}))
+	defer deployment.Close()
+
+	original := httptest.NewRequest(http.MethodPut, "http://shop.example.com/cart?item=1", nil)
+	original.Header.Set("Content-Type", "application/json")
+	original.Header.Set("X-Request-Id", "abc")
+	original.Header.Set("Keep-Alive", "timeout=5")
+	body, err := NewBody(strings.NewReader(`{"item":1}`), 4, 1024, t.TempDir())
+	assert.NoError(t, err)
+	defer body.Close()
+	recorder := httptest.NewRecorder()
+
+	assert.NoError(t, uut.Replay(context.Background(), recorder, original, deployment.URL+"/cart?item=1", body))
+
+	if assert.NotNil(t, received) {
+		assert.Equal(t, http.MethodPut, received.Method)
+		assert.Equal(t, "/cart?item=1", received.RequestURI)
+		assert.Equal(t, "shop.example.com", received.Host)
+		assert.Equal(t, "application/json", received.Header.Get("Content-Type"))
+		assert.Equal(t, "abc", received.Header.Get("X-Request-Id"))
+		assert.Empty(t, received.Header.Get("Keep-Alive"))
+		assert.Equal(t, int64(10), received.ContentLength)
+		assert.Equal(t, `{"item":1}`, receivedBody)
+	}
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Equal(t, "42", recorder.Header().Get("X-Order"))
+	assert.Equal(t, "12", recorder.Header().Get("Content-Length"))
+	assert.Equal(t, `{"order":42}`, recorder.Body.String())
+}
+
+func Test_Replay_HandsRedirectsBackToTheCaller(t *testing.T) {
+	deployment := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}))
+	defer deployment.Close()
+
+	original := httptest.NewRequest(http.MethodPost, "http://shop.example.com/checkout", nil)
+	body, err := NewBody(strings.NewReader(""), 4, 1024, t.TempDir())
+	assert.NoError(t, err)
+	defer body.Close()
+	recorder := httptest.NewRecorder()
+
+	assert.NoError(t, uut.Replay(context.Background(), recorder, original, deployment.URL+"/checkout", body))
+
+	assert.Equal(t, http.StatusSeeOther, recorder.Code)
+	assert.Equal(t, "/login", recorder.Header().Get("Location"))
+}
+
+func Test_Replay_WritesNothingIfTheDeploymentCannotBeReached(t *testing.T) {
+	deployment := httptest.NewServer(http.NotFoundHandler())
+	deployment.Close()
+
+	original := httptest.NewRequest(http.MethodPost, "http://shop.example.com/checkout", nil)
+	body, err := NewBody(strings.NewReader("order"), 4, 1024, t.TempDir())
+	assert.NoError(t, err)
+	defer body.Close()
+	recorder := httptest.NewRecorder()
+
+	assert.Error(t, uut.Replay(context.Background(), recorder, original, deployment.URL+"/checkout", body))
+
+	assert.False(t, recorder.Flushed)
+	assert.Empty(t, recorder.Body.String())
+	assert.Empty(t, recorder.Header())
+}
@@ -0,0 +1,89 @@
+package routing
+
+import (
+	"fmt"
+	"o-neko-catnip/pkg/config"
+	"regexp"
+	"strings"
+)
+
+// Names of the capture groups that can be used in regular expression host patterns.
+const (
+	ProjectIdGroup   = "projectId"
+	ProjectNameGroup = "projectName"
+	VersionIdGroup   = "versionId"
+	VersionNameGroup = "versionName"
+)
+
+// HostMatch contains the values captured from a host by a matching host pattern. Fields are empty if the pattern did not
+// capture them.
+type HostMatch struct {
+	ProjectId   string
+	ProjectName string
+	VersionId   string
+	VersionName string
+}
+
+// HostMatcher matches hosts against the configured glob and regular expression host patterns.
+type HostMatcher struct {
+	patterns []*regexp.Regexp
+}
+
+func NewHostMatcher(patterns []config.HostPatternConfig) (*HostMatcher, error) {
+	matcher := &HostMatcher{}
+	for _, pattern := range patterns {
+		var expression string
+		if len(pattern.Regex) > 0 {
+			// like globs, regular expressions must match the whole host, so hosts merely containing it are not matched
+			expression = "^(?:" + pattern.Regex + ")$"
+		} else {
+			expression = globToRegex(pattern.Glob)
+		}
+		compiled, err := regexp.Compile("(?i)" + expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %w", expression, err)
+		}
+		matcher.patterns = append(matcher.patterns, compiled)
+	}
+	return matcher, nil
+}
+
+// Match returns the captures of the first pattern matching the host.
+func (m *HostMatcher) Match(host string) (*HostMatch, bool) {
+	for _, pattern := range m.patterns {
+		submatches := pattern.FindStringSubmatch(host)
+		if submatches == nil {
+			continue
+		}
+		match := &HostMatch{}
+		for i, name := range pattern.SubexpNames() {
+			switch name {
+			case ProjectIdGroup:
+				match.ProjectId = submatches[i]
+			case ProjectNameGroup:
+				match.ProjectName = submatches[i]
+			case VersionIdGroup:
+				match.VersionId = submatches[i]
+			case VersionNameGroup:
+				match.VersionName = submatches[i]
+			}
+		}
+		return match, true
+	}
+	return nil, false
+}
+
+func (m *HostMatcher) Matches(host string) bool {
+	_, matches := m.Match(host)
+	return matches
+}
+
+// globToRegex converts a host glob like *.preview.example.com to an anchored regular expression. The wildcard may
+// span multiple labels of the host.
+func globToRegex(glob string) string {
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return "^" + strings.Join(parts, ".+") + "$"
+}
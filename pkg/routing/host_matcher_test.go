@@ -0,0 +1,75 @@
+package routing
+
+import (
+	"o-neko-catnip/pkg/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HostMatcher_Glob(t *testing.T) {
+	matcher, err := NewHostMatcher([]config.HostPatternConfig{
+		{Glob: "*.preview.example.com"},
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, matcher.Matches("feature-x.preview.example.com"))
+	assert.True(t, matcher.Matches("feature-x.shop.preview.example.com"))
+	assert.True(t, matcher.Matches("Feature-X.Preview.Example.com"))
+	assert.False(t, matcher.Matches("preview.example.com"))
+	assert.False(t, matcher.Matches("feature-x.preview.example.com.evil.com"))
+	assert.False(t, matcher.Matches("feature-x-preview.example.com"))
+}
+
+func Test_HostMatcher_RegexCaptures(t *testing.T) {
+	matcher, err := NewHostMatcher([]config.HostPatternConfig{
+		{Regex: `^(?P<versionName>[^.]+)\.(?P<projectName>[^.]+)\.preview\.example\.com$`},
+		{Regex: `^(?P<versionId>[0-9a-f-]+)\.(?P<projectId>[0-9a-f-]+)\.ids\.example\.com$`},
+	})
+	assert.NoError(t, err)
+
+	match, matches := matcher.Match("feature-x.shop.preview.example.com")
+	assert.True(t, matches)
+	assert.Equal(t, &HostMatch{ProjectName: "shop", VersionName: "feature-x"}, match)
+
+	match, matches = matcher.Match("5eb9c99f.63638583.ids.example.com")
+	assert.True(t, matches)
+	assert.Equal(t, &HostMatch{ProjectId: "63638583", VersionId: "5eb9c99f"}, match)
+
+	_, matches = matcher.Match("shop.preview.example.com")
+	assert.False(t, matches)
+}
+
+func Test_HostMatcher_RegexMatchesTheWholeHost(t *testing.T) {
+	matcher, err := NewHostMatcher([]config.HostPatternConfig{
+		{Regex: `preview\.example\.com`},
+		{Regex: `shop|(?P<versionName>[^.]+)\.ids\.example\.com`},
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, matcher.Matches("preview.example.com"))
+	assert.True(t, matcher.Matches("Preview.Example.com"))
+	assert.False(t, matcher.Matches("preview.example.com.evil.com"))
+	assert.False(t, matcher.Matches("evil-preview.example.com"))
+
+	// the anchors apply to all alternatives
+	assert.True(t, matcher.Matches("shop"))
+	assert.False(t, matcher.Matches("shop.evil.com"))
+	match, matches := matcher.Match("feature-x.ids.example.com")
+	assert.True(t, matches)
+	assert.Equal(t, "feature-x", match.VersionName)
+	assert.False(t, matcher.Matches("evil.com.feature-x.ids.example.com.evil.com"))
+}
+
+func Test_HostMatcher_InvalidRegex(t *testing.T) {
+	_, err := NewHostMatcher([]config.HostPatternConfig{
+		{Regex: `^(unclosed`},
+	})
+	assert.Error(t, err)
+}
+
+func Test_HostMatcher_NoPatterns(t *testing.T) {
+	matcher, err := NewHostMatcher(nil)
+	assert.NoError(t, err)
+	assert.False(t, matcher.Matches("anything.example.com"))
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"o-neko-catnip/pkg/config"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// defaultBotUserAgentPatterns match the link unfurlers of common chat and ticket systems as well as search engines.
+var defaultBotUserAgentPatterns = []string{
+	`Slackbot`,
+	`Slack-ImgProxy`,
+	`SkypeUriPreview`,
+	`MicrosoftPreview`,
+	`Atlassian`,
+	`Mattermost`,
+	`Discordbot`,
+	`TelegramBot`,
+	`WhatsApp`,
+	`facebookexternalhit`,
+	`Twitterbot`,
+	`LinkedInBot`,
+	`Googlebot`,
+	`bingbot`,
+	`DuckDuckBot`,
+	`Applebot`,
+	`YandexBot`,
+	`\bbot\b`,
+	`crawler`,
+	`spider`,
+}
+
+const robotsTxt = "User-agent: *\nDisallow: /\n"
+
+const (
+	suppressedByUserAgent = "user_agent"
+	suppressedByMethod    = "method"
+	suppressedByPrefetch  = "prefetch"
+)
+
+// botDetector recognizes requests that must not wake up a deployment because no human is going to look at it.
+type botDetector struct {
+	enabled           bool
+	userAgentPatterns []*regexp.Regexp
+	suppressedCounter *prometheus.CounterVec
+}
+
+func newBotDetector(botsConfig config.BotsConfig) (*botDetector, error) {
+	detector := &botDetector{
+		enabled: botsConfig.Enabled,
+		suppressedCounter: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "oneko_catnip_suppressed_wakeups_total",
+			Help: "The number of wake-ups suppressed because the request came from a bot or was a prefetch.",
+		}, []string{"reason"}),
+	}
+	for _, pattern := range append(defaultBotUserAgentPatterns, botsConfig.UserAgentPatterns...) {
+		compiled, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user agent pattern %q: %w", pattern, err)
+		}
+		detector.userAgentPatterns = append(detector.userAgentPatterns, compiled)
+	}
+	return detector, nil
+}
+
+// detect returns the reason why the request must not wake up a deployment, or an empty string for regular requests.
+func (b *botDetector) detect(r *http.Request) string {
+	if !b.enabled {
+		return ""
+	}
+	if r.Method == http.MethodHead {
+		return suppressedByMethod
+	}
+	if isPrefetch(r) {
+		return suppressedByPrefetch
+	}
+	userAgent := r.UserAgent()
+	for _, pattern := range b.userAgentPatterns {
+		if pattern.MatchString(userAgent) {
+			return suppressedByUserAgent
+		}
+	}
+	return ""
+}
+
+func isPrefetch(r *http.Request) bool {
+	for _, header := range []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"} {
+		if strings.Contains(strings.ToLower(r.Header.Get(header)), "prefetch") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *TriggerServer) handleRobotsTxt(c *gin.Context) {
+	c.String(http.StatusOK, robotsTxt)
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BotDetector(t *testing.T) {
+	assertDetected := func(expectedReason, method string, headers map[string]string) {
+		r := httptest.NewRequest(method, "/", nil)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		assert.Equal(t, expectedReason, uut.bots.detect(r), headers)
+	}
+
+	assertDetected("", http.MethodGet, map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64; rv:123.0) Gecko/20100101 Firefox/123.0"})
+	assertDetected("", http.MethodGet, map[string]string{"User-Agent": "curl/8.5.0"})
+
+	assertDetected(suppressedByUserAgent, http.MethodGet, map[string]string{"User-Agent": "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"})
+	assertDetected(suppressedByUserAgent, http.MethodGet, map[string]string{"User-Agent": "Mozilla/5.0 (Windows NT 6.1; WOW64) SkypeUriPreview Preview/0.5"})
+	assertDetected(suppressedByUserAgent, http.MethodGet, map[string]string{"User-Agent": "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"})
+	assertDetected(suppressedByUserAgent, http.MethodGet, map[string]string{"User-Agent": "InternalLinkChecker/1.2"})
+
+	assertDetected(suppressedByMethod, http.MethodHead, map[string]string{})
+	assertDetected(suppressedByPrefetch, http.MethodGet, map[string]string{"Purpose": "prefetch"})
+	assertDetected(suppressedByPrefetch, http.MethodGet, map[string]string{"Sec-Purpose": "prefetch;prerender"})
+}
@@ -5,43 +5,58 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"net/http"
 	"o-neko-catnip/pkg/oneko/service"
-	"o-neko-catnip/pkg/utils"
-	"time"
+	"strings"
 )
 
 type catnipMux struct {
 	defaultHandler http.Handler
 	otherHandler   http.Handler
 	svc            *service.Service
-	domains        *utils.Memoized[*utils.Set[string]]
-	domainCount    prometheus.Gauge
+	catnipHost     string
+	proxies        *trustedProxies
 }
 
-func newMux(defaultHandler, otherHandler http.Handler, svc *service.Service) catnipMux {
-	domainCount := promauto.NewGauge(prometheus.GaugeOpts{
+func newMux(defaultHandler, otherHandler http.Handler, svc *service.Service, catnipHost string, proxies *trustedProxies) catnipMux {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
 		Name: "oneko_catnip_oneko_projectversion_domains",
 		Help: "The number of unique domains across all O-Neko projects and versions",
-	})
-	memoize := utils.Memoize(15*time.Second, func() (*utils.Set[string], error) {
-		domains := svc.GetAllProjectDomains()
-		domainCount.Set(float64(domains.Size()))
-		return domains, nil
+	}, func() float64 {
+		return float64(svc.GetAllProjectDomains().Size())
 	})
 	return catnipMux{
 		defaultHandler: defaultHandler,
 		otherHandler:   otherHandler,
 		svc:            svc,
-		domains:        memoize,
-		domainCount:    domainCount,
+		catnipHost:     catnipHost,
+		proxies:        proxies,
 	}
 }
 
 func (m catnipMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	domains, err := m.domains.Get()
+	r = m.proxies.apply(r)
 
-	if err != nil || !domains.Contains(r.Host) {
-		m.defaultHandler.ServeHTTP(w, r)
-	} else {
+	if m.isDeploymentHost(r.Host) {
 		m.otherHandler.ServeHTTP(w, r)
+	} else {
+		m.defaultHandler.ServeHTTP(w, r)
+	}
+}
+
+// isDeploymentHost compares hosts regardless of their case. Versions may be exposed on a port of their own, so the
+// host is only looked up without its port if no version uses the port.
+func (m catnipMux) isDeploymentHost(host string) bool {
+	host = strings.ToLower(host)
+	if host == m.catnipHost {
+		return false
+	}
+	// the domains come from the url index, which is refreshed in the background
+	domains := m.svc.GetAllProjectDomains()
+	if domains.Contains(host) {
+		return true
+	}
+	hostWithoutPort := service.GetHostWithoutPort(host)
+	if hostWithoutPort == m.catnipHost {
+		return false
 	}
+	return m.svc.MatchesHostPattern(hostWithoutPort) || domains.Contains(hostWithoutPort)
 }
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Mux_RoutesDeploymentHostsRegardlessOfCaseAndPort(t *testing.T) {
+	proxies, err := newTrustedProxies(nil)
+	assert.NoError(t, err)
+	var handledBy string
+	mux := catnipMux{
+		defaultHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handledBy = "catnip" }),
+		otherHandler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handledBy = "deployment" }),
+		svc:            uut.oneko,
+		catnipHost:     "catnip.example.com",
+		proxies:        proxies,
+	}
+	route := func(host string) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = host
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+		return handledBy
+	}
+
+	assert.Equal(t, "deployment", route(otherVersionUrl))
+	assert.Equal(t, "deployment", route(strings.ToUpper(otherVersionUrl)))
+	assert.Equal(t, "deployment", route(otherVersionUrl+":443"))
+	assert.Equal(t, "deployment", route(strings.TrimPrefix(deploymentServer.URL, "http://")))
+	assert.Equal(t, "catnip", route("catnip.example.com"))
+	assert.Equal(t, "catnip", route("Catnip.Example.com:8080"))
+	assert.Equal(t, "catnip", route("unknown.oneko.company.cloud"))
+
+	// hosts matching a host pattern are routed to the deployments before they are indexed
+	assert.Equal(t, "deployment", route("feature.preview.oneko.company.cloud"))
+	assert.Equal(t, "deployment", route("Demoversion."+projectUuid+".apps.oneko.company.cloud:8443"))
+	assert.Equal(t, "catnip", route("preview.oneko.company.cloud"))
+	assert.Equal(t, "catnip", route("feature.preview.oneko.company.cloud.evil.com"))
+}
+
+func Test_ProjectUrlRequest_UsesTheCapturesOfHostPatterns(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/cart", nil)
+	c.Request.Host = "demoversion." + projectUuid + ".apps.oneko.company.cloud"
+
+	uut.handleGetRequestToProjectUrl(c)
+
+	assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+	wakeupUrl, err := url.Parse(recorder.Header().Get("Location"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, versionUuid, wakeupUrl.Query().Get("versionId"))
+		assert.Equal(t, "http://demoversion."+projectUuid+".apps.oneko.company.cloud/cart", wakeupUrl.Query().Get("redirectTo"))
+	}
+}
+
+func Test_ProjectUrlRequest_RendersTheErrorPageForUnknownHostsMatchingAPattern(t *testing.T) {
+	templates, err := loadTemplates(os.DirFS("../../frontend"), nil, uut.templateFuncs())
+	assert.NoError(t, err)
+	for _, host := range []string{"feature.preview.oneko.company.cloud", "unknown." + projectUuid + ".apps.oneko.company.cloud"} {
+		recorder := httptest.NewRecorder()
+		c, engine := gin.CreateTestContext(recorder)
+		engine.SetHTMLTemplate(templates)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Host = host
+
+		uut.handleGetRequestToProjectUrl(c)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code, host)
+		assert.Contains(t, recorder.Header().Get("Content-Type"), "text/html", host)
+		assert.Contains(t, recorder.Body.String(), "no project found with url "+host, host)
+	}
+}
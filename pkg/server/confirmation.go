@@ -0,0 +1,95 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/oneko/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The confirmation form is protected by a double-submit cookie: the token of the form must match the token of the
+// cookie, which other sites can neither read nor send along due to its SameSite attribute. Unlike a server-side
+// secret this works across multiple catnip instances. The API accepts the token in a header instead of the form.
+const (
+	csrfCookieName   = "catnip_csrf"
+	csrfFormField    = "csrfToken"
+	csrfHeader       = "oneko-catnip-csrf-token"
+	csrfTokenBytes   = 32
+	csrfCookieMaxAge = 60 * 60
+	csrfCookiePath   = "/"
+)
+
+type confirmationRequiredResponse struct {
+	Error           string `json:"error"`
+	ConfirmationUrl string `json:"confirmationUrl"`
+}
+
+func (s *TriggerServer) isConfirmationRequiredFor(project *oneko.Project, version *oneko.ProjectVersion) bool {
+	return project.MatchesAny(s.configuration.ONeko.Confirmation.Projects) || s.oneko.EvaluateRules(project, version).Action == service.RuleConfirm
+}
+
+// abortWithConfirmationRequired sends browsers to the confirmation page. Other clients are told where to confirm the
+// wake-up, as they cannot confirm it themselves.
+func (s *TriggerServer) abortWithConfirmationRequired(project *oneko.Project, version *oneko.ProjectVersion, deploymentUrl string, c *gin.Context) {
+	s.log.Info("wake-up needs to be confirmed", slog.String("project", project.Name), slog.String("version", version.Name))
+	confirmationUrl := s.getWakeupUrl(project, version, deploymentUrl, c)
+	if _, wait := getRequestedWaitDuration(c.Request, 0); c.Request.Method == http.MethodGet && !wait {
+		c.Redirect(http.StatusTemporaryRedirect, confirmationUrl)
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusConflict, confirmationRequiredResponse{
+		Error:           fmt.Sprintf("waking up version %s of project %s needs to be confirmed", version.Name, project.Name),
+		ConfirmationUrl: confirmationUrl,
+	})
+}
+
+func (s *TriggerServer) renderConfirmationPage(project *oneko.Project, version *oneko.ProjectVersion, c *gin.Context) {
+	token, err := issueCsrfToken(c)
+	if err != nil {
+		s.log.Error("failed to issue csrf token", slog.Any("error", err))
+		s.renderErrorPage(http.StatusInternalServerError, err, c)
+		return
+	}
+	parameters := s.newTemplateParameters(c, project, version)
+	parameters.CsrfToken = token
+	parameters.DeploymentUrl = c.Query("redirectTo")
+	c.HTML(http.StatusOK, "confirm.html", parameters)
+}
+
+// issueCsrfToken reuses the token of an existing cookie so confirmation pages opened in multiple tabs stay valid.
+func issueCsrfToken(c *gin.Context) (string, error) {
+	token, err := c.Cookie(csrfCookieName)
+	if err != nil || len(token) != 2*csrfTokenBytes {
+		random := make([]byte, csrfTokenBytes)
+		if _, err := rand.Read(random); err != nil {
+			return "", err
+		}
+		token = hex.EncodeToString(random)
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(csrfCookieName, token, csrfCookieMaxAge, csrfCookiePath, "", getProtocol(c) == "https", true)
+	return token, nil
+}
+
+// isValidCsrfToken reports whether the request confirms a wake-up.
+func isValidCsrfToken(c *gin.Context) bool {
+	cookieToken, err := c.Cookie(csrfCookieName)
+	if err != nil || len(cookieToken) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookieToken), []byte(submittedCsrfToken(c))) == 1
+}
+
+func submittedCsrfToken(c *gin.Context) string {
+	if token := c.GetHeader(csrfHeader); len(token) > 0 {
+		return token
+	}
+	return c.PostForm(csrfFormField)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EveryWakeupPath_AsksForTheConfirmation(t *testing.T) {
+	deployCallsBefore := deployCalls.Load()
+	assertConfirmationRequired := func(recorder *httptest.ResponseRecorder, deploymentUrl string) {
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		var response confirmationRequiredResponse
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		confirmationUrl, err := url.Parse(response.ConfirmationUrl)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "catnip.example.com", confirmationUrl.Host)
+			assert.Equal(t, "/wakeup", confirmationUrl.Path)
+			assert.Equal(t, confirmUuid, confirmationUrl.Query().Get("versionId"))
+			assert.Equal(t, deploymentUrl, confirmationUrl.Query().Get("redirectTo"))
+		}
+	}
+
+	t.Run("api", func(t *testing.T) {
+		assertConfirmationRequired(requestWakeup(confirmServer.URL+"/pay"), confirmServer.URL+"/pay")
+	})
+	t.Run("waiting clients", func(t *testing.T) {
+		recorder := requestProjectUrl(confirmServer, "/pay", map[string]string{"Accept": "application/json"})
+		assertConfirmationRequired(recorder, confirmServer.URL+"/pay")
+	})
+	t.Run("replayed requests", func(t *testing.T) {
+		recorder := replayRequest(confirmServer, http.MethodPost, "/pay", strings.NewReader("order"))
+		assertConfirmationRequired(recorder, confirmServer.URL+"/pay")
+	})
+	t.Run("proxy mode", func(t *testing.T) {
+		enableProxyMode(t, 100*time.Millisecond)
+
+		recorder := requestProjectUrl(confirmServer, "/pay", map[string]string{"Prefer": "wait=10"})
+		assertConfirmationRequired(recorder, confirmServer.URL+"/pay")
+
+		// browsers are sent to the confirmation page
+		recorder = requestProjectUrl(confirmServer, "/pay", map[string]string{"Accept": "text/html"})
+		assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+		assert.True(t, strings.HasPrefix(recorder.Header().Get("Location"), "http://catnip.example.com/wakeup?"))
+	})
+
+	assert.Equal(t, deployCallsBefore, deployCalls.Load())
+	assert.Nil(t, confirmServer.last.Load())
+}
+
+func Test_WakeupRequest_AcceptsTheTokenOfTheConfirmation(t *testing.T) {
+	deployCallsBefore := deployCalls.Load()
+	wakeup := func(cookieToken, headerToken string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/wakeup?deploymentUrl="+url.QueryEscape(confirmServer.URL), nil)
+		c.Request.AddCookie(&http.Cookie{Name: csrfCookieName, Value: cookieToken})
+		c.Request.Header.Set(csrfHeader, headerToken)
+		uut.handleWakeupRequest(c)
+		return recorder
+	}
+
+	// a cross-site request can neither read the cookie nor set it
+	assert.Equal(t, http.StatusConflict, wakeup("abc123", "def456").Code)
+	assert.Equal(t, http.StatusConflict, wakeup("", "abc123").Code)
+	assert.Equal(t, deployCallsBefore, deployCalls.Load())
+
+	assert.Equal(t, http.StatusAccepted, wakeup("abc123", "abc123").Code)
+	assert.Equal(t, deployCallsBefore+1, deployCalls.Load())
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type forwardedProtoKey struct{}
+
+// trustedProxies decides whether the forwarding headers of a request can be trusted based on its remote address.
+type trustedProxies struct {
+	networks []*net.IPNet
+}
+
+func newTrustedProxies(cidrsOrIps []string) (*trustedProxies, error) {
+	proxies := &trustedProxies{}
+	for _, cidrOrIp := range cidrsOrIps {
+		if !strings.Contains(cidrOrIp, "/") {
+			if strings.Contains(cidrOrIp, ":") {
+				cidrOrIp += "/128"
+			} else {
+				cidrOrIp += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidrOrIp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidrOrIp, err)
+		}
+		proxies.networks = append(proxies.networks, network)
+	}
+	return proxies, nil
+}
+
+func (t *trustedProxies) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the request with the host and protocol seen by the client if it has been forwarded by a trusted proxy.
+// The RFC 7239 Forwarded header takes precedence over the X-Forwarded-* headers.
+func (t *trustedProxies) apply(r *http.Request) *http.Request {
+	if !t.isTrusted(r.RemoteAddr) {
+		return r
+	}
+
+	proto, host := parseForwardedHeader(r.Header.Get("Forwarded"))
+	if len(proto) == 0 {
+		proto = firstListValue(r.Header.Get("X-Forwarded-Proto"))
+	}
+	if len(host) == 0 {
+		host = firstListValue(r.Header.Get("X-Forwarded-Host"))
+		if port := firstListValue(r.Header.Get("X-Forwarded-Port")); len(host) > 0 && len(port) > 0 && !hasPort(host) && !isDefaultPort(proto, port) {
+			host = net.JoinHostPort(host, port)
+		}
+	}
+
+	if len(proto) == 0 && len(host) == 0 {
+		return r
+	}
+
+	ctx := r.Context()
+	if len(proto) > 0 {
+		ctx = context.WithValue(ctx, forwardedProtoKey{}, strings.ToLower(proto))
+	}
+	forwarded := r.WithContext(ctx)
+	if len(host) > 0 {
+		forwarded.Host = host
+	}
+	return forwarded
+}
+
+// parseForwardedHeader returns the proto and host parameters of the first element of an RFC 7239 Forwarded header,
+// which has been added by the proxy closest to the client.
+func parseForwardedHeader(header string) (proto, host string) {
+	first, _, _ := strings.Cut(header, ",")
+	for _, pair := range strings.Split(first, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(key) {
+		case "proto":
+			proto = value
+		case "host":
+			host = value
+		}
+	}
+	return proto, host
+}
+
+func firstListValue(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(first)
+}
+
+func hasPort(host string) bool {
+	_, _, err := net.SplitHostPort(host)
+	return err == nil
+}
+
+func isDefaultPort(proto, port string) bool {
+	return (port == "443" && strings.EqualFold(proto, "https")) || (port == "80" && !strings.EqualFold(proto, "https"))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TrustedProxies_IgnoresHeadersOfUntrustedClients(t *testing.T) {
+	proxies, err := newTrustedProxies([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+
+	r := newForwardedRequest("192.168.1.1:1234")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "evil.example.com")
+
+	applied := proxies.apply(r)
+	assert.Equal(t, "catnip.internal", applied.Host)
+	assert.Nil(t, applied.Context().Value(forwardedProtoKey{}))
+}
+
+func Test_TrustedProxies_XForwardedHeaders(t *testing.T) {
+	proxies, err := newTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	assert.NoError(t, err)
+
+	r := newForwardedRequest("10.1.2.3:1234")
+	r.Header.Set("X-Forwarded-Proto", "https, http")
+	r.Header.Set("X-Forwarded-Host", "shop.preview.example.com")
+	r.Header.Set("X-Forwarded-Port", "8443")
+
+	applied := proxies.apply(r)
+	assert.Equal(t, "shop.preview.example.com:8443", applied.Host)
+	assert.Equal(t, "https", applied.Context().Value(forwardedProtoKey{}))
+
+	r = newForwardedRequest("192.168.1.1:1234")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "shop.preview.example.com")
+	r.Header.Set("X-Forwarded-Port", "443")
+
+	applied = proxies.apply(r)
+	assert.Equal(t, "shop.preview.example.com", applied.Host)
+}
+
+func Test_TrustedProxies_ForwardedHeaderTakesPrecedence(t *testing.T) {
+	proxies, err := newTrustedProxies([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+
+	r := newForwardedRequest("10.1.2.3:1234")
+	r.Header.Set("Forwarded", `for=192.0.2.60;proto=https;host="shop.preview.example.com", for=10.1.2.4;proto=http`)
+	r.Header.Set("X-Forwarded-Proto", "http")
+	r.Header.Set("X-Forwarded-Host", "other.example.com")
+
+	applied := proxies.apply(r)
+	assert.Equal(t, "shop.preview.example.com", applied.Host)
+	assert.Equal(t, "https", applied.Context().Value(forwardedProtoKey{}))
+}
+
+func Test_TrustedProxies_InvalidConfiguration(t *testing.T) {
+	_, err := newTrustedProxies([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
+
+func newForwardedRequest(remoteAddr string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://catnip.internal/some/path", nil)
+	r.RemoteAddr = remoteAddr
+	return r
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"o-neko-catnip/pkg/deployment"
+	"o-neko-catnip/pkg/groups"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/oneko/service"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// groupMember is a member of the group of the requested version together with the URL its status is checked with.
+type groupMember struct {
+	groups.Member
+	probeUrl string
+}
+
+type memberStatus struct {
+	deployment.StatusResponse
+	versionIdentity
+	Required      bool `json:"required"`
+	QueuePosition int  `json:"queuePosition,omitempty"`
+}
+
+// getGroupMembers returns the requested version followed by the other members of its groups. The groups are resolved
+// with the url index, the members are then looked up again, as their state may have changed since it was built.
+func (s *TriggerServer) getGroupMembers(project *oneko.Project, version *oneko.ProjectVersion) []groups.Member {
+	members := s.groups.Members(project, version)
+	for i := 1; i < len(members); i++ {
+		if project, version, err := s.oneko.GetProjectAndVersionByIds(members[i].Project.Uuid, members[i].Version.Uuid); err == nil {
+			members[i].Project = project
+			members[i].Version = version
+		}
+	}
+	return members
+}
+
+// getGroupMembersWithProbeUrls is getGroupMembers for checking the status of the group. Members without a URL cannot
+// be checked and are left out.
+func (s *TriggerServer) getGroupMembersWithProbeUrls(protocol string, match *service.UrlMatch) []groupMember {
+	members := s.getGroupMembers(match.Project, match.Version)
+	result := []groupMember{{Member: members[0], probeUrl: getProbeUrl(protocol, match)}}
+	for _, member := range members[1:] {
+		if len(member.Version.Urls) == 0 {
+			continue
+		}
+		result = append(result, groupMember{Member: member, probeUrl: getMemberProbeUrl(protocol, member.Version.Urls[0])})
+	}
+	return result
+}
+
+// getMemberProbeUrl uses the protocol of the member's URL if it has one and the protocol of the request otherwise.
+func getMemberProbeUrl(protocol string, memberUrl string) string {
+	if parsed, err := url.Parse(memberUrl); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+		protocol = parsed.Scheme
+	}
+	return fmt.Sprintf("%s://%s", protocol, service.GetDeploymentUrlPrefix(memberUrl))
+}
+
+// isGroupDeployed reports whether the version and all other members of its groups are deployed.
+func (s *TriggerServer) isGroupDeployed(project *oneko.Project, version *oneko.ProjectVersion) bool {
+	for _, member := range s.getGroupMembers(project, version) {
+		if !member.Version.IsDeployed() {
+			return false
+		}
+	}
+	return true
+}
+
+// wake wakes up all members of the version's groups which are not deployed. It returns the position of the version
+// itself in the wake-up queue. Failing to wake up another member is logged only, the version is still usable on its
+// own. The version must have passed checkWakeup, members needing a confirmation are only woken up by confirmed
+// requests.
+func (s *TriggerServer) wake(c *gin.Context, project *oneko.Project, version *oneko.ProjectVersion) (int, error) {
+	members := s.getGroupMembers(project, version)
+	position := 0
+	if !version.IsDeployed() {
+		var err error
+		if position, err = s.capacity.Wake(c, project, version); err != nil {
+			return 0, err
+		}
+	}
+	for _, member := range members[1:] {
+		if member.Version.IsDeployed() {
+			continue
+		}
+		if !s.oneko.EvaluateRules(member.Project, member.Version).Allowed() {
+			s.log.Debug("not waking up member of group denied by rule", slog.String("project", member.Project.Name), slog.String("version", member.Version.Name))
+			continue
+		}
+		if s.isConfirmationRequiredFor(member.Project, member.Version) && !isValidCsrfToken(c) {
+			s.log.Debug("not waking up member of group without confirmation", slog.String("project", member.Project.Name), slog.String("version", member.Version.Name))
+			continue
+		}
+		s.log.Debug("waking up member of group", slog.String("project", member.Project.Name), slog.String("version", member.Version.Name))
+		if _, err := s.capacity.Wake(c, member.Project, member.Version); err != nil {
+			s.log.Warn("failed to wake up member of group", slog.String("project", member.Project.Name), slog.String("version", member.Version.Name), slog.Any("error", err))
+		}
+	}
+	return position, nil
+}
+
+// getMemberStatuses describes the other members of the group. Members whose status is not known yet are pending.
+func (s *TriggerServer) getMemberStatuses(others []groupMember, statuses []*deployment.StatusResponse) []memberStatus {
+	if len(others) == 0 {
+		return nil
+	}
+	result := make([]memberStatus, 0, len(others))
+	for i, member := range others {
+		status := statuses[i]
+		if status == nil {
+			status = &deployment.StatusResponse{DeploymentStatus: deployment.Pending, RedirectUrl: member.probeUrl}
+		}
+		result = append(result, memberStatus{
+			StatusResponse:  *status,
+			versionIdentity: newVersionIdentity(member.Project, member.Version),
+			Required:        member.Required,
+			QueuePosition:   s.capacity.QueuePosition(member.Version.Uuid),
+		})
+	}
+	return result
+}
+
+// getGroupStatus combines the status of the requested version with the statuses of the other members. The group is
+// only ready once all required members are ready.
+func getGroupStatus(status *deployment.StatusResponse, members []memberStatus) deployment.StatusResponse {
+	combined := *status
+	if combined.DeploymentStatus != deployment.Ready {
+		return combined
+	}
+	for _, member := range members {
+		if !member.Required || member.DeploymentStatus == deployment.Ready {
+			continue
+		}
+		combined.DeploymentStatus = member.DeploymentStatus
+		combined.ErrorMessage = member.ErrorMessage
+		if member.DeploymentStatus == deployment.Error {
+			return combined
+		}
+	}
+	return combined
+}
+
+// loadMemberStatuses checks the status of every member, nil for members whose status could not be loaded.
+func (s *TriggerServer) loadMemberStatuses(members []groupMember) []*deployment.StatusResponse {
+	statuses := make([]*deployment.StatusResponse, len(members))
+	for i, member := range members {
+		if status, err := s.monitor.DeploymentStatus(member.probeUrl); err == nil {
+			statuses[i] = status
+		}
+	}
+	return statuses
+}
+
+// waitUntilGroupReady waits until the requested version and all required members are ready, in total not longer than
+// the timeout.
+func (s *TriggerServer) waitUntilGroupReady(ctx context.Context, members []groupMember, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for _, member := range members {
+		if !member.Required {
+			continue
+		}
+		if _, err := s.monitor.WaitUntilReady(ctx, member.probeUrl, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
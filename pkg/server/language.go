@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The language of the pages follows the Accept-Language header unless the user picked one. Links with the query
+// parameter store the choice in a cookie.
+const (
+	languageCookieName     = "catnip_language"
+	languageQueryParameter = "language"
+	languageCookieMaxAge   = 365 * 24 * 60 * 60
+	languageContextKey     = "catnip.language"
+)
+
+// language returns the language to render the page in.
+func (s *TriggerServer) language(c *gin.Context) string {
+	if lang := c.GetString(languageContextKey); len(lang) > 0 {
+		return lang
+	}
+
+	var lang string
+	if chosen := c.Query(languageQueryParameter); s.i18n.Supports(chosen) {
+		lang = strings.ToLower(chosen)
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(languageCookieName, lang, languageCookieMaxAge, "/", "", getProtocol(c) == "https", true)
+	} else if cookie, err := c.Cookie(languageCookieName); err == nil && s.i18n.Supports(cookie) {
+		lang = strings.ToLower(cookie)
+	} else {
+		lang = s.i18n.Match(c.GetHeader("Accept-Language"))
+	}
+	c.Header("Vary", "Accept-Language, Cookie")
+	c.Set(languageContextKey, lang)
+	return lang
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"o-neko-catnip/pkg/oneko"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newLanguageTestContext(target string, headers map[string]string) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	for header, value := range headers {
+		c.Request.Header.Set(header, value)
+	}
+	return c, recorder
+}
+
+func Test_Language_CookieOverridesTheBrowser(t *testing.T) {
+	c, _ := newLanguageTestContext("/", map[string]string{"Accept-Language": "de-DE,de;q=0.9,en;q=0.8"})
+	assert.Equal(t, "de", uut.language(c))
+
+	c, _ = newLanguageTestContext("/", map[string]string{"Accept-Language": "ja"})
+	assert.Equal(t, "en", uut.language(c))
+
+	c, _ = newLanguageTestContext("/", map[string]string{"Accept-Language": "de", "Cookie": languageCookieName + "=en"})
+	assert.Equal(t, "en", uut.language(c))
+
+	// unsupported languages in the cookie are ignored
+	c, _ = newLanguageTestContext("/", map[string]string{"Accept-Language": "de", "Cookie": languageCookieName + "=xx"})
+	assert.Equal(t, "de", uut.language(c))
+
+	c, recorder := newLanguageTestContext("/wakeup?language=DE", map[string]string{"Cookie": languageCookieName + "=en"})
+	assert.Equal(t, "de", uut.language(c))
+	cookies := recorder.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, languageCookieName, cookies[0].Name)
+		assert.Equal(t, "de", cookies[0].Value)
+	}
+}
+
+func Test_Templates_AreTranslated(t *testing.T) {
+	templates, err := loadTemplates(os.DirFS("../../frontend"), nil, uut.templateFuncs())
+	assert.NoError(t, err)
+
+	c, _ := newLanguageTestContext("/wakeup?projectId=p&versionId=v", map[string]string{"Accept-Language": "de"})
+	parameters := uut.newTemplateParameters(c, &oneko.Project{Name: "shop"}, &oneko.ProjectVersion{Name: "<main>"})
+	parameters.Contact = "the platform team"
+
+	var rendered bytes.Buffer
+	assert.NoError(t, templates.ExecuteTemplate(&rendered, "denied.html", parameters))
+	assert.Contains(t, rendered.String(), `<html lang="de">`)
+	assert.Contains(t, rendered.String(), `Version <span class="version-badge">&lt;main&gt;</span> von Projekt <span class="project-badge">shop</span>`)
+	assert.Contains(t, rendered.String(), `Frag bitte <strong>the platform team</strong>`)
+	assert.Contains(t, rendered.String(), `href="/wakeup?language=en&amp;projectId=p&amp;versionId=v"`)
+
+	parameters.Error = uut.i18n.Error(parameters.Language, uut.validateRedirectTarget("ftp://shop.example.com", &oneko.Project{}, &oneko.ProjectVersion{}))
+	rendered.Reset()
+	assert.NoError(t, templates.ExecuteTemplate(&rendered, "error.html", parameters))
+	assert.Contains(t, rendered.String(), `&#34;ftp://shop.example.com&#34; ist keine gültige Deployment-URL`)
+}
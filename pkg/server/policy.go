@@ -0,0 +1,67 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/policy"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminTokenHeader carries the configured admin token to wake up deployments regardless of the policy.
+const adminTokenHeader = "oneko-catnip-admin-token"
+
+type policyViolationResponse struct {
+	Error             string    `json:"error"`
+	NextAllowedWakeup time.Time `json:"nextAllowedWakeup"`
+}
+
+// checkWakeupPolicy must only be called for versions which are not deployed, running deployments can always be used.
+func (s *TriggerServer) checkWakeupPolicy(project *oneko.Project, c *gin.Context) policy.Decision {
+	if s.hasAdminToken(c) {
+		return policy.Decision{Allowed: true}
+	}
+	decision := s.policy.Check(project)
+	if !decision.Allowed {
+		s.log.Info("wake-up not allowed by policy", slog.String("project", project.Name), slog.Time("nextAllowed", decision.NextAllowed))
+	}
+	return decision
+}
+
+func (s *TriggerServer) hasAdminToken(c *gin.Context) bool {
+	adminToken := s.configuration.ONeko.Policy.AdminToken
+	if len(adminToken) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(adminToken), []byte(c.GetHeader(adminTokenHeader))) == 1
+}
+
+func (s *TriggerServer) renderPolicyViolationPage(project *oneko.Project, version *oneko.ProjectVersion, decision policy.Decision, c *gin.Context) {
+	setRetryAfter(decision, c)
+	parameters := s.newTemplateParameters(c, project, version)
+	parameters.NextWakeup = decision.NextAllowed
+	c.HTML(http.StatusForbidden, "quiet.html", parameters)
+}
+
+func abortWithPolicyViolation(project *oneko.Project, decision policy.Decision, c *gin.Context) {
+	setRetryAfter(decision, c)
+	c.AbortWithStatusJSON(http.StatusForbidden, policyViolationResponse{
+		Error:             fmt.Sprintf("deployments of project %s cannot be woken up at this time", project.Name),
+		NextAllowedWakeup: decision.NextAllowed,
+	})
+}
+
+// setRetryAfter rounds the time until the next allowed wake-up up to full seconds.
+func setRetryAfter(decision policy.Decision, c *gin.Context) {
+	if decision.NextAllowed.IsZero() {
+		return
+	}
+	seconds := int(math.Ceil(decision.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(seconds, 1)))
+}
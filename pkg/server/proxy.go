@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/oneko/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxiedHeader marks requests forwarded by catnip. Receiving it means the ingress still routes the
+// deployment's host to catnip and proxying again would loop.
+const proxiedHeader = "oneko-catnip-proxied"
+
+func (s *TriggerServer) isProxyModeEnabledFor(project *oneko.Project) bool {
+	proxyConfig := s.configuration.ONeko.Proxy
+	return proxyConfig.Enabled || project.MatchesAny(proxyConfig.Projects)
+}
+
+func (s *TriggerServer) proxyRequestToProjectUrl(match *service.UrlMatch, c *gin.Context) {
+	if len(c.GetHeader(proxiedHeader)) > 0 {
+		c.Header("Retry-After", retryAfterSeconds)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+
+	target := &url.URL{
+		Scheme: getProtocol(c),
+		Host:   c.Request.Host,
+	}
+	deploymentUrl := fmt.Sprintf("%s://%s%s", target.Scheme, target.Host, c.Request.RequestURI)
+
+	if !s.isGroupDeployed(match.Project, match.Version) {
+		if check := s.checkWakeup(match.Project, match.Version, c); !check.allowed() {
+			s.abortWithWakeupViolation(match.Project, match.Version, deploymentUrl, check, c)
+			return
+		}
+		_, err := s.wake(c, match.Project, match.Version)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if _, err := s.monitor.WaitUntilReady(c.Request.Context(), getProbeUrl(target.Scheme, match), s.configuration.ONeko.Proxy.Timeout); err != nil {
+		s.log.Info("deployment did not become ready in time", slog.String("url", deploymentUrl), slog.Any("error", err))
+		c.Header("Retry-After", retryAfterSeconds)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+
+	s.log.Debug("proxying request to deployment", slog.String("project", match.Project.Name), slog.String("version", match.Version.Name), slog.String("url", deploymentUrl))
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.Out.Host = r.In.Host
+			r.SetXForwarded()
+			r.Out.Header.Set(proxiedHeader, s.appVersion)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			s.log.Info("failed to proxy request to deployment", slog.String("url", deploymentUrl), slog.Any("error", err))
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"o-neko-catnip/pkg/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// enableProxyMode switches all projects to proxy mode and checks deployments more often than the default monitor.
+func enableProxyMode(t *testing.T, timeout time.Duration) {
+	proxyConfig := uut.configuration.ONeko.Proxy
+	uut.configuration.ONeko.Proxy = config.ProxyConfig{Enabled: true, Timeout: timeout}
+	useFastMonitor(t)
+	t.Cleanup(func() {
+		uut.configuration.ONeko.Proxy = proxyConfig
+	})
+}
+
+// closeNotifyingRecorder can be used with the reverse proxy, which expects the writer of gin to be a CloseNotifier.
+type closeNotifyingRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r closeNotifyingRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func requestProjectUrl(deployment *countingServer, target string, headers map[string]string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(closeNotifyingRecorder{recorder})
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	c.Request.Host = strings.TrimPrefix(deployment.URL, "http://")
+	for header, value := range headers {
+		c.Request.Header.Set(header, value)
+	}
+	uut.handleGetRequestToProjectUrl(c)
+	c.Writer.WriteHeaderNow()
+	return recorder
+}
+
+func Test_ProxyMode_WaitsForTheDeploymentAndProxiesTheRequest(t *testing.T) {
+	enableProxyMode(t, 5*time.Second)
+	sleepingServer.pending.Store(true)
+	t.Cleanup(func() { sleepingServer.pending.Store(false) })
+	deployCallsBefore := deployCalls.Load()
+
+	time.AfterFunc(100*time.Millisecond, func() {
+		sleepingServer.pending.Store(false)
+	})
+	recorder := requestProjectUrl(sleepingServer, "/cart?tab=checkout", nil)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, deployCallsBefore+1, deployCalls.Load())
+	last := sleepingServer.last.Load()
+	if assert.NotNil(t, last) {
+		assert.Equal(t, http.MethodGet, last.method)
+		assert.Equal(t, "/cart?tab=checkout", last.requestUri)
+		assert.Equal(t, "test", last.header.Get(proxiedHeader))
+		assert.Equal(t, strings.TrimPrefix(sleepingServer.URL, "http://"), last.header.Get("X-Forwarded-Host"))
+	}
+}
+
+func Test_ProxyMode_DetectsLoops(t *testing.T) {
+	enableProxyMode(t, 5*time.Second)
+	requestsBefore := shopServer.requests.Load()
+
+	recorder := requestProjectUrl(shopServer, "/", map[string]string{proxiedHeader: "test"})
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, retryAfterSeconds, recorder.Header().Get("Retry-After"))
+	assert.Equal(t, requestsBefore, shopServer.requests.Load())
+}
+
+func Test_ProxyMode_GivesUpIfTheDeploymentDoesNotBecomeReady(t *testing.T) {
+	enableProxyMode(t, 100*time.Millisecond)
+
+	recorder := requestProjectUrl(startingServer, "/", nil)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, retryAfterSeconds, recorder.Header().Get("Retry-After"))
+	assert.Nil(t, startingServer.last.Load())
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"o-neko-catnip/pkg/replay"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HEAD is deliberately missing: the deployment monitor uses it to probe deployments.
+var replayedMethods = []string{
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+const retryAfterSeconds = "10"
+
+func (s *TriggerServer) handleReplayedRequestToProjectUrl(c *gin.Context) {
+	replayConfig := s.configuration.ONeko.Replay
+
+	match, err := s.oneko.MatchUrl(fmt.Sprintf("%s%s", c.Request.Host, c.Request.RequestURI))
+	if err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	s.idle.Seen(match.Version.Uuid)
+
+	if c.Request.ContentLength > replayConfig.MaxBodySize {
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body, err := replay.NewBody(c.Request.Body, replayConfig.MaxMemoryBodySize, replayConfig.MaxBodySize, replayConfig.SpoolDirectory)
+	if errors.Is(err, replay.ErrBodyTooLarge) {
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer func() {
+		if err := body.Close(); err != nil {
+			s.log.Warn("failed to release buffered request body", slog.Any("error", err))
+		}
+	}()
+
+	deploymentUrl := fmt.Sprintf("%s://%s%s", getProtocol(c), c.Request.Host, c.Request.RequestURI)
+
+	if !s.isGroupDeployed(match.Project, match.Version) {
+		if check := s.checkWakeup(match.Project, match.Version, c); !check.allowed() {
+			s.abortWithWakeupViolation(match.Project, match.Version, deploymentUrl, check, c)
+			return
+		}
+		_, err := s.wake(c, match.Project, match.Version)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	s.log.Debug("holding request until the deployment is ready", slog.String("project", match.Project.Name), slog.String("version", match.Version.Name), slog.String("method", c.Request.Method), slog.Int64("bodySize", body.Size()))
+
+	if _, err := s.monitor.WaitUntilReady(c.Request.Context(), getProbeUrl(getProtocol(c), match), replayConfig.Timeout); err != nil {
+		s.log.Info("deployment did not become ready in time, dropping buffered request", slog.String("url", deploymentUrl), slog.Any("error", err))
+		c.Header("Retry-After", retryAfterSeconds)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := s.replayer.Replay(c.Request.Context(), c.Writer, c.Request, deploymentUrl, body); err != nil {
+		_ = c.AbortWithError(http.StatusBadGateway, err)
+	}
+}
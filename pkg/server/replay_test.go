@@ -0,0 +1,66 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func replayRequest(deployment *countingServer, method string, target string, body io.Reader) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, target, body)
+	c.Request.Host = strings.TrimPrefix(deployment.URL, "http://")
+	uut.handleReplayedRequestToProjectUrl(c)
+	c.Writer.WriteHeaderNow()
+	return recorder
+}
+
+func Test_ReplayedRequest_IsSentToTheDeployment(t *testing.T) {
+	content := strings.Repeat("x", 40)
+
+	recorder := replayRequest(shopServer, http.MethodPatch, "/orders/42?notify=true", strings.NewReader(content))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	last := shopServer.last.Load()
+	if assert.NotNil(t, last) {
+		assert.Equal(t, http.MethodPatch, last.method)
+		assert.Equal(t, "/orders/42?notify=true", last.requestUri)
+		assert.Equal(t, content, last.body)
+		// the body exceeds the in-memory limit, so it is spooled to disk until it has been sent
+		assert.Equal(t, 1, last.spooledBodies)
+	}
+	entries, err := os.ReadDir(spoolDirectory)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_ReplayedRequest_RejectsOversizedBodies(t *testing.T) {
+	requestsBefore := shopServer.requests.Load()
+
+	recorder := replayRequest(shopServer, http.MethodPost, "/orders", strings.NewReader(strings.Repeat("x", 65)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	// bodies of unknown length are cut off once they exceed the limit
+	recorder = replayRequest(shopServer, http.MethodPost, "/orders", io.MultiReader(strings.NewReader(strings.Repeat("x", 65))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	assert.Equal(t, requestsBefore, shopServer.requests.Load())
+	entries, err := os.ReadDir(spoolDirectory)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_ReplayedRequest_IsDroppedIfTheDeploymentDoesNotBecomeReady(t *testing.T) {
+	recorder := replayRequest(startingServer, http.MethodPost, "/orders", strings.NewReader("order"))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, retryAfterSeconds, recorder.Header().Get("Retry-After"))
+	assert.Nil(t, startingServer.last.Load())
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/oneko/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ruleViolationResponse struct {
+	Error      string `json:"error"`
+	Reason     string `json:"reason,omitempty"`
+	Contact    string `json:"contact,omitempty"`
+	RedirectTo string `json:"redirectTo,omitempty"`
+}
+
+// checkWakeupRules must only be called for versions which are not deployed. Unlike the policy the rules cannot be
+// bypassed with the admin token, the service refuses to trigger denied versions anyway.
+func (s *TriggerServer) checkWakeupRules(project *oneko.Project, version *oneko.ProjectVersion) service.RuleDecision {
+	decision := s.oneko.EvaluateRules(project, version)
+	if !decision.Allowed() {
+		s.log.Info("wake-up not allowed by rule", slog.String("project", project.Name), slog.String("version", version.Name), slog.String("action", string(decision.Action)))
+	}
+	return decision
+}
+
+// renderRuleViolationPage explains why the version cannot be woken up, or sends the user elsewhere.
+func (s *TriggerServer) renderRuleViolationPage(project *oneko.Project, version *oneko.ProjectVersion, decision service.RuleDecision, c *gin.Context) {
+	if decision.Action == service.RuleRedirect {
+		c.Redirect(http.StatusFound, decision.RedirectTo)
+		return
+	}
+	parameters := s.newTemplateParameters(c, project, version)
+	parameters.Reason = decision.Reason
+	parameters.Contact = decision.Contact
+	c.HTML(http.StatusForbidden, "denied.html", parameters)
+}
+
+// abortWithRuleViolation is used for clients which do not see the wakeup page. GET requests follow redirect rules.
+func abortWithRuleViolation(project *oneko.Project, version *oneko.ProjectVersion, decision service.RuleDecision, c *gin.Context) {
+	if decision.Action == service.RuleRedirect && c.Request.Method == http.MethodGet {
+		c.Redirect(http.StatusFound, decision.RedirectTo)
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, ruleViolationResponse{
+		Error:      fmt.Sprintf("version %s of project %s cannot be woken up", version.Name, project.Name),
+		Reason:     decision.Reason,
+		Contact:    decision.Contact,
+		RedirectTo: decision.RedirectTo,
+	})
+}
@@ -8,14 +8,24 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"o-neko-catnip/frontend"
+	"o-neko-catnip/pkg/capacity"
 	"o-neko-catnip/pkg/config"
 	"o-neko-catnip/pkg/deployment"
+	"o-neko-catnip/pkg/groups"
+	"o-neko-catnip/pkg/history"
+	"o-neko-catnip/pkg/i18n"
+	"o-neko-catnip/pkg/idle"
 	"o-neko-catnip/pkg/logger"
 	"o-neko-catnip/pkg/metrics"
 	"o-neko-catnip/pkg/oneko"
 	"o-neko-catnip/pkg/oneko/service"
+	"o-neko-catnip/pkg/policy"
+	"o-neko-catnip/pkg/replay"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -28,14 +38,56 @@ type TriggerServer struct {
 	log           *slog.Logger
 	oneko         *service.Service
 	monitor       *deployment.DeploymentMonitor
+	replayer      *replay.Replayer
+	bots          *botDetector
+	policy        *policy.Policy
+	capacity      *capacity.Scheduler
+	idle          *idle.Tracker
+	groups        *groups.Groups
+	i18n          *i18n.Catalogs
+	history       *history.History
 	appVersion    string
 }
 
 func New(c *config.Config, context context.Context, appVersion string) *TriggerServer {
+	bots, err := newBotDetector(c.ONeko.Bots)
+	if err != nil {
+		panic(err)
+	}
+	wakeupPolicy, err := policy.New(c.ONeko.Policy)
+	if err != nil {
+		panic(err)
+	}
+	catalogs, err := i18n.New(c.ONeko.I18n.DefaultLanguage, frontend.Locales(), subFS(newThemeFS(c.ONeko.Theme), "locales"))
+	if err != nil {
+		panic(err)
+	}
+	oneko := service.New(c, context)
+	// deployments are woken up through the tracker, so it knows which ones to stop once they are idle
+	idleTracker := idle.New(context, c.ONeko.Idle, oneko)
+	if c.ONeko.Idle.Enabled {
+		activitySource, err := idle.NewQuerySource(c.ONeko.Idle)
+		if err != nil {
+			panic(err)
+		}
+		idleTracker.AddActivitySource(activitySource)
+	}
+	// the history measures the time from triggering a deployment until the monitor first finds it ready
+	wakeupHistory := history.New(context, c.ONeko.History, idleTracker)
+	monitor := deployment.New()
+	monitor.OnReady(wakeupHistory.Ready)
 	return &TriggerServer{
 		log:           logger.New("server"),
-		oneko:         service.New(c, context),
-		monitor:       deployment.New(),
+		oneko:         oneko,
+		monitor:       monitor,
+		replayer:      replay.New(),
+		bots:          bots,
+		policy:        wakeupPolicy,
+		capacity:      capacity.New(context, c.ONeko.Capacity, wakeupHistory),
+		idle:          idleTracker,
+		history:       wakeupHistory,
+		groups:        groups.New(c.ONeko.Groups, oneko),
+		i18n:          catalogs,
 		configuration: c,
 		appVersion:    appVersion,
 	}
@@ -50,9 +102,21 @@ func (s *TriggerServer) Start() {
 		gin.SetMode(gin.DebugMode)
 	}
 
+	proxies, err := newTrustedProxies(s.configuration.ONeko.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("trusted proxies: %s\n", err)
+	}
+
 	mainHandler := gin.New()
 	otherHandler := gin.New()
 
+	// client IPs are only taken from the X-Forwarded-For header of trusted proxies
+	for _, handler := range []*gin.Engine{mainHandler, otherHandler} {
+		if err := handler.SetTrustedProxies(s.configuration.ONeko.Server.TrustedProxies); err != nil {
+			log.Fatalf("trusted proxies: %s\n", err)
+		}
+	}
+
 	// logging
 	slogMiddleware := sloggin.NewWithFilters(s.log, sloggin.IgnorePath("/up", "/metrics"))
 	mainHandler.Use(slogMiddleware)
@@ -62,27 +126,52 @@ func (s *TriggerServer) Start() {
 	otherHandler.Use(gin.Recovery())
 	otherHandler.Use(s.catnipHeaderHandler())
 
-	// custom template functions
-	mainHandler.SetFuncMap(template.FuncMap{
-		"formatAsDate": formatAsDate,
-	})
+	// files of the theme replace the built-in ones with the same name
+	theme := newThemeFS(s.configuration.ONeko.Theme)
+	builtin, live := s.builtinFrontend()
+	templates, err := loadTemplates(builtin, theme, s.templateFuncs())
+	if err != nil {
+		log.Fatalf("templates: %s\n", err)
+	}
+	// the preview page for bots is rendered on deployment hosts as well
+	for _, handler := range []*gin.Engine{mainHandler, otherHandler} {
+		if live {
+			handler.HTMLRender = reloadingRender{load: func() (*template.Template, error) {
+				return loadTemplates(builtin, theme, s.templateFuncs())
+			}}
+		} else {
+			handler.SetHTMLTemplate(templates)
+		}
+	}
 
-	mainHandler.LoadHTMLGlob("frontend/dist/*.html")
-	mainHandler.Static("/assets/", "frontend/dist/assets/")
-	mainHandler.StaticFile("/favicon.ico", "public/assets/favicon.ico")
+	assets := newStaticFiles(staticLayer{files: subFS(theme, "assets")}, staticLayer{files: subFS(builtin, "assets"), immutable: !live})
+	favicon := newStaticFiles(staticLayer{files: subFS(theme, "assets")}, staticLayer{files: frontend.Favicon(), immutable: true})
+	mainHandler.GET("/assets/*filepath", assets.handler("filepath"))
+	mainHandler.GET("/favicon.ico", func(c *gin.Context) {
+		favicon.serve(c, "favicon.ico")
+	})
 
 	mainHandler.GET("/", s.handleGetRequestToCatnipHome)
+	mainHandler.GET("/robots.txt", s.handleRobotsTxt)
 	mainHandler.GET("/wakeup", s.handleGetRequestToWakeupUrl)
+	mainHandler.POST("/wakeup", s.handlePostRequestToWakeupUrl)
 	mainHandler.NoRoute(s.redirectToHomePage)
 
 	apiHandler := mainHandler.Group("/api")
 	apiHandler.GET("/status", s.handleStatusRequest)
+	apiHandler.GET("/status/stream", s.handleStatusStreamRequest)
+	apiHandler.POST("/wakeup", s.handleWakeupRequest)
 
 	otherHandler.GET("/*any", s.handleGetRequestToProjectUrl)
+	if s.configuration.ONeko.Replay.Enabled {
+		for _, method := range replayedMethods {
+			otherHandler.Handle(method, "/*any", s.handleReplayedRequestToProjectUrl)
+		}
+	}
 
 	address := fmt.Sprintf(":%d", s.configuration.ONeko.Server.Port)
 
-	mux := newMux(mainHandler, otherHandler, s.oneko)
+	mux := newMux(mainHandler, otherHandler, s.oneko, s.configuration.ONeko.CatnipUrl, proxies)
 
 	var servers []*http.Server
 
@@ -129,72 +218,263 @@ func (s *TriggerServer) Start() {
 	}
 }
 
-type templateParameters struct {
-	Project oneko.Project
-	Version oneko.ProjectVersion
-	BaseUrl string
+type versionIdentity struct {
+	ProjectUuid  string             `json:"projectUuid"`
+	ProjectName  string             `json:"projectName"`
+	VersionUuid  string             `json:"versionUuid"`
+	VersionName  string             `json:"versionName"`
+	DesiredState oneko.DesiredState `json:"desiredState"`
+	Deployment   oneko.Deployment   `json:"deployment"`
+}
+
+func newVersionIdentity(project *oneko.Project, version *oneko.ProjectVersion) versionIdentity {
+	return versionIdentity{
+		ProjectUuid:  project.Uuid,
+		ProjectName:  project.Name,
+		VersionUuid:  version.Uuid,
+		VersionName:  version.Name,
+		DesiredState: version.DesiredState,
+		Deployment:   version.Deployment,
+	}
+}
+
+type statusResponse struct {
+	deployment.StatusResponse
+	versionIdentity
+	QueuePosition int `json:"queuePosition,omitempty"`
+	// Stale is set while the project data has been read from the persisted index and not been confirmed by O-Neko
+	Stale bool `json:"stale,omitempty"`
+	// Members are the other versions of the groups of the version. DeploymentStatus is only Ready once all required
+	// members are ready as well.
+	Members []memberStatus `json:"members,omitempty"`
+	// EstimatedReadyAt is when the deployment is expected to be ready judging by its previous wake-ups
+	EstimatedReadyAt *time.Time `json:"estimatedReadyAt,omitempty"`
+}
+
+type wakeupResponse struct {
+	versionIdentity
+	Triggered     bool `json:"triggered"`
+	QueuePosition int  `json:"queuePosition,omitempty"`
 }
 
 func (s *TriggerServer) handleGetRequestToCatnipHome(c *gin.Context) {
-	c.HTML(http.StatusOK, "index.html", templateParameters{
-		BaseUrl: s.configuration.ONeko.Api.BaseUrl,
-	})
+	c.HTML(http.StatusOK, "index.html", s.newTemplateParameters(c, nil, nil))
 }
 
 func (s *TriggerServer) handleGetRequestToWakeupUrl(c *gin.Context) {
-	projectId := c.Query("projectId")
-	versionId := c.Query("versionId")
+	project, version, ok := s.getProjectAndVersionOfWakeupRequest(c)
+	if !ok {
+		return
+	}
 
-	project, version, err := s.oneko.GetProjectAndVersionByIds(projectId, versionId)
+	if reason := s.bots.detect(c.Request); len(reason) > 0 {
+		s.renderPreviewPage(project, version, reason, c)
+		return
+	}
 
-	if err != nil {
-		c.HTML(http.StatusBadRequest, "error.html", gin.H{
-			"error":   err.Error(),
-			"BaseUrl": s.configuration.ONeko.Api.BaseUrl,
-		})
+	if !s.isGroupDeployed(project, version) {
+		if check := s.checkWakeup(project, version, c); !check.allowed() {
+			s.renderWakeupViolationPage(project, version, check, c)
+			return
+		}
+	}
+
+	// the page wakes the deployment up through the API, so loading it has no side effects
+	s.renderWakeupPage(project, version, s.capacity.QueuePosition(version.Uuid), c)
+}
+
+func (s *TriggerServer) handlePostRequestToWakeupUrl(c *gin.Context) {
+	project, version, ok := s.getProjectAndVersionOfWakeupRequest(c)
+	if !ok {
 		return
 	}
 
-	if !version.IsDeployed() {
-		err := s.oneko.TriggerDeployment(projectId, versionId, c)
-		if err != nil {
-			c.HTML(http.StatusBadRequest, "error.html", gin.H{
-				"error":   err.Error(),
-				"BaseUrl": s.configuration.ONeko.Api.BaseUrl,
-			})
+	if !isValidCsrfToken(c) {
+		s.renderErrorPage(http.StatusForbidden, i18n.Errorf("error.confirmationExpired", "the confirmation has expired, please reload the page and try again"), c)
+		return
+	}
+
+	if !s.isGroupDeployed(project, version) {
+		if check := s.checkWakeup(project, version, c); !check.allowed() {
+			s.renderWakeupViolationPage(project, version, check, c)
 			return
 		}
 	}
 
-	c.HTML(http.StatusOK, "wakeup.html", templateParameters{
-		Project: *project,
-		Version: *version,
-		BaseUrl: s.configuration.ONeko.Api.BaseUrl,
-	})
+	s.triggerDeploymentAndRenderWakeupPage(project, version, c)
+}
+
+// wakeupCheck is the outcome of checking the rules, the policy and the confirmation of a wake-up, in this order.
+type wakeupCheck struct {
+	rule                 service.RuleDecision
+	policy               policy.Decision
+	confirmationRequired bool
+}
+
+func (w wakeupCheck) allowed() bool {
+	return w.rule.Allowed() && w.policy.Allowed && !w.confirmationRequired
+}
+
+// checkWakeup must be called by every request before it wakes up a version which is not deployed. Later checks are
+// skipped once one fails.
+func (s *TriggerServer) checkWakeup(project *oneko.Project, version *oneko.ProjectVersion, c *gin.Context) wakeupCheck {
+	check := wakeupCheck{rule: s.checkWakeupRules(project, version)}
+	if !check.rule.Allowed() {
+		return check
+	}
+	if check.policy = s.checkWakeupPolicy(project, c); !check.policy.Allowed {
+		return check
+	}
+	check.confirmationRequired = s.isConfirmationRequiredFor(project, version) && !isValidCsrfToken(c)
+	return check
+}
+
+// renderWakeupViolationPage explains to the user why the version has not been woken up, or asks for a confirmation.
+func (s *TriggerServer) renderWakeupViolationPage(project *oneko.Project, version *oneko.ProjectVersion, check wakeupCheck, c *gin.Context) {
+	switch {
+	case !check.rule.Allowed():
+		s.renderRuleViolationPage(project, version, check.rule, c)
+	case !check.policy.Allowed:
+		s.renderPolicyViolationPage(project, version, check.policy, c)
+	default:
+		s.renderConfirmationPage(project, version, c)
+	}
+}
+
+// abortWithWakeupViolation is renderWakeupViolationPage for clients which do not see the wakeup page. deploymentUrl
+// is the URL the client wanted to use, the confirmation page sends the user there once the deployment is ready.
+func (s *TriggerServer) abortWithWakeupViolation(project *oneko.Project, version *oneko.ProjectVersion, deploymentUrl string, check wakeupCheck, c *gin.Context) {
+	switch {
+	case !check.rule.Allowed():
+		abortWithRuleViolation(project, version, check.rule, c)
+	case !check.policy.Allowed:
+		abortWithPolicyViolation(project, check.policy, c)
+	default:
+		s.abortWithConfirmationRequired(project, version, deploymentUrl, c)
+	}
+}
+
+func (s *TriggerServer) getProjectAndVersionOfWakeupRequest(c *gin.Context) (*oneko.Project, *oneko.ProjectVersion, bool) {
+	project, version, err := s.oneko.GetProjectAndVersionByIds(c.Query("projectId"), c.Query("versionId"))
+	if err != nil {
+		s.renderErrorPage(http.StatusBadRequest, err, c)
+		return nil, nil, false
+	}
+
+	if redirectTo, exists := c.GetQuery("redirectTo"); exists {
+		if err := s.validateRedirectTarget(redirectTo, project, version); err != nil {
+			s.renderErrorPage(http.StatusBadRequest, err, c)
+			return nil, nil, false
+		}
+	}
+	return project, version, true
+}
+
+func (s *TriggerServer) triggerDeploymentAndRenderWakeupPage(project *oneko.Project, version *oneko.ProjectVersion, c *gin.Context) {
+	queuePosition, err := s.wake(c, project, version)
+	if err != nil {
+		s.renderErrorPage(http.StatusBadRequest, err, c)
+		return
+	}
+	s.renderWakeupPage(project, version, queuePosition, c)
+}
+
+func (s *TriggerServer) renderWakeupPage(project *oneko.Project, version *oneko.ProjectVersion, queuePosition int, c *gin.Context) {
+	parameters := s.newTemplateParameters(c, project, version)
+	parameters.QueuePosition = queuePosition
+	parameters.DeploymentUrl = c.Query("redirectTo")
+	// the page triggers the deployment through the API, which needs the token of a confirmed wake-up as well
+	if isValidCsrfToken(c) {
+		parameters.CsrfToken = submittedCsrfToken(c)
+	}
+	c.HTML(http.StatusOK, "wakeup.html", parameters)
+}
+
+func (s *TriggerServer) renderErrorPage(status int, err error, c *gin.Context) {
+	parameters := s.newTemplateParameters(c, nil, nil)
+	parameters.Error = s.i18n.Error(parameters.Language, err)
+	c.HTML(status, "error.html", parameters)
+}
+
+// validateRedirectTarget makes sure the wakeup page only redirects to URLs of the version it is waking up.
+func (s *TriggerServer) validateRedirectTarget(redirectTo string, project *oneko.Project, version *oneko.ProjectVersion) error {
+	match, err := s.oneko.MatchDeploymentUrl(redirectTo)
+	if err != nil {
+		return err
+	}
+	if match.Project.Uuid != project.Uuid || match.Version.Uuid != version.Uuid {
+		return i18n.Errorf("error.foreignDeploymentUrl", "%q does not belong to version %s of project %s", redirectTo, version.Name, project.Name)
+	}
+	return nil
+}
+
+// renderPreviewPage describes the deployment to link-preview bots and prefetching browsers without waking it up.
+func (s *TriggerServer) renderPreviewPage(project *oneko.Project, version *oneko.ProjectVersion, reason string, c *gin.Context) {
+	s.log.Debug("suppressed wake-up", slog.String("project", project.Name), slog.String("version", version.Name), slog.String("reason", reason), slog.String("userAgent", c.Request.UserAgent()))
+	s.bots.suppressedCounter.WithLabelValues(reason).Inc()
+	c.Header("X-Robots-Tag", "noindex, nofollow")
+	c.HTML(http.StatusOK, "preview.html", s.newTemplateParameters(c, project, version))
 }
 
 func (s *TriggerServer) handleGetRequestToProjectUrl(c *gin.Context) {
 	s.log.Debug("incoming request to non-default url", slog.String("host", c.Request.Host))
-	project, version, err := s.oneko.GetProjectAndVersionForUrl(fmt.Sprintf("%s%s", c.Request.Host, c.Request.RequestURI))
+	if c.Request.URL.Path == "/robots.txt" {
+		s.handleRobotsTxt(c)
+		return
+	}
+	match, err := s.oneko.MatchUrl(fmt.Sprintf("%s%s", c.Request.Host, c.Request.RequestURI))
 	if err != nil {
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+		// hosts matching a host pattern are routed here even if they do not belong to a version
+		s.renderErrorPage(http.StatusNotFound, err, c)
 		return
 	}
-	s.log.Debug("request to url of project version", slog.String("project", project.Name), slog.String("version", version.Name))
-	redirectUrl := s.getRedirectUrl(project, version, c)
+	s.log.Debug("request to url of project version", slog.String("project", match.Project.Name), slog.String("version", match.Version.Name))
+	if reason := s.bots.detect(c.Request); len(reason) > 0 {
+		s.renderPreviewPage(match.Project, match.Version, reason, c)
+		return
+	}
+	s.idle.Seen(match.Version.Uuid)
+	if s.isProxyModeEnabledFor(match.Project) {
+		s.proxyRequestToProjectUrl(match, c)
+		return
+	}
+	if waitDuration, wait := getRequestedWaitDuration(c.Request, s.configuration.ONeko.Wait.MaxDuration); wait {
+		s.waitForDeployment(match, waitDuration, c)
+		return
+	}
+	redirectUrl := s.getRedirectUrl(match.Project, match.Version, c)
 	s.log.Debug("redirecting", slog.String("url", redirectUrl))
 	c.Redirect(http.StatusTemporaryRedirect, redirectUrl)
 }
 
+// getRedirectUrl returns the URL of the wakeup page. The complete original request URI including the query is passed
+// on in the redirectTo parameter. Browsers keep the fragment of the original URL when following the redirect, so the
+// wakeup page can restore it from its own location.
 func (s *TriggerServer) getRedirectUrl(project *oneko.Project, version *oneko.ProjectVersion, c *gin.Context) string {
-	protocol := getProtocol(c) + "://"
-	return fmt.Sprintf("%s%s/wakeup?projectId=%s&versionId=%s&redirectTo=%s%s%s", protocol, s.configuration.ONeko.CatnipUrl, project.Uuid, version.Uuid, protocol, c.Request.Host, c.Request.URL.Path)
+	protocol := getProtocol(c)
+	return s.getWakeupUrl(project, version, fmt.Sprintf("%s://%s%s", protocol, c.Request.Host, c.Request.RequestURI), c)
+}
+
+// getWakeupUrl returns the URL of the wakeup page sending the user to redirectTo once the deployment is ready.
+func (s *TriggerServer) getWakeupUrl(project *oneko.Project, version *oneko.ProjectVersion, redirectTo string, c *gin.Context) string {
+	wakeupUrl := url.URL{
+		Scheme: getProtocol(c),
+		Host:   s.configuration.ONeko.CatnipUrl,
+		Path:   "/wakeup",
+		RawQuery: url.Values{
+			"projectId":  {project.Uuid},
+			"versionId":  {version.Uuid},
+			"redirectTo": {redirectTo},
+		}.Encode(),
+	}
+	return wakeupUrl.String()
 }
 
 func (s *TriggerServer) redirectToHomePage(c *gin.Context) {
 	c.Redirect(http.StatusTemporaryRedirect, "/")
 }
 
+// handleStatusRequest reports the status of a deployment without side effects. Use handleWakeupRequest to start it.
 func (s *TriggerServer) handleStatusRequest(c *gin.Context) {
 	deploymentUrl, exists := c.GetQuery("deploymentUrl")
 	if !exists {
@@ -202,29 +482,99 @@ func (s *TriggerServer) handleStatusRequest(c *gin.Context) {
 		return
 	}
 
-	project, version, err := s.oneko.GetProjectAndVersionForUrl(deploymentUrl)
+	match, err := s.oneko.MatchDeploymentUrl(deploymentUrl)
 	if err != nil {
-		_ = c.AbortWithError(http.StatusBadRequest, err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if !version.IsDeployed() {
-		err = s.oneko.TriggerDeployment(project.Uuid, version.Uuid, c)
-		if err != nil {
-			_ = c.AbortWithError(http.StatusBadRequest, err)
-			return
-		}
+	members := s.getGroupMembersWithProbeUrls(getProtocolOfUrl(deploymentUrl), match)
+	status, err := s.monitor.DeploymentStatus(members[0].probeUrl)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
+	memberStatuses := s.getMemberStatuses(members[1:], s.loadMemberStatuses(members[1:]))
+	response := statusResponse{
+		StatusResponse:  getGroupStatus(status, memberStatuses),
+		versionIdentity: newVersionIdentity(match.Project, match.Version),
+		QueuePosition:   s.capacity.QueuePosition(match.Version.Uuid),
+		Stale:           match.Stale,
+		Members:         memberStatuses,
+	}
+	response.RedirectUrl = deploymentUrl
+	response.EstimatedReadyAt = s.estimateReadyAt(response.DeploymentStatus, match.Version.Uuid)
+	c.JSON(http.StatusOK, response)
+}
 
-	status, err := s.monitor.DeploymentStatus(deploymentUrl)
+// handleWakeupRequest triggers the deployment of the version the URL belongs to unless it is deployed or queued
+// already, so repeating the request has no further effect.
+func (s *TriggerServer) handleWakeupRequest(c *gin.Context) {
+	deploymentUrl, exists := c.GetQuery("deploymentUrl")
+	if !exists {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	match, err := s.oneko.MatchDeploymentUrl(deploymentUrl)
 	if err != nil {
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if s.isGroupDeployed(match.Project, match.Version) {
+		c.JSON(http.StatusOK, wakeupResponse{
+			versionIdentity: newVersionIdentity(match.Project, match.Version),
+			Triggered:       false,
+		})
+		return
+	}
+
+	if check := s.checkWakeup(match.Project, match.Version, c); !check.allowed() {
+		s.abortWithWakeupViolation(match.Project, match.Version, deploymentUrl, check, c)
+		return
+	}
+
+	queuePosition, err := s.wake(c, match.Project, match.Version)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, status)
+	c.JSON(http.StatusAccepted, wakeupResponse{
+		versionIdentity: newVersionIdentity(match.Project, match.Version),
+		Triggered:       queuePosition == 0,
+		QueuePosition:   queuePosition,
+	})
+}
+
+// estimateReadyAt returns nil if the deployment is ready or there is no estimate.
+func (s *TriggerServer) estimateReadyAt(status deployment.DeploymentStatus, versionUuid string) *time.Time {
+	if status == deployment.Ready {
+		return nil
+	}
+	if readyAt, ok := s.history.EstimateReadyAt(versionUuid); ok {
+		return &readyAt
+	}
+	return nil
+}
+
+// getProbeUrl returns the URL the deployment monitor checks for all requests to the matched version.
+func getProbeUrl(protocol string, match *service.UrlMatch) string {
+	return fmt.Sprintf("%s://%s", protocol, match.UrlPrefix)
+}
+
+func getProtocolOfUrl(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || len(parsed.Scheme) == 0 {
+		return "http"
+	}
+	return strings.ToLower(parsed.Scheme)
 }
 
 func getProtocol(c *gin.Context) string {
+	if proto, ok := c.Request.Context().Value(forwardedProtoKey{}).(string); ok {
+		return proto
+	}
 	if c.Request.TLS != nil {
 		return "https"
 	} else {
@@ -232,10 +582,6 @@ func getProtocol(c *gin.Context) string {
 	}
 }
 
-func formatAsDate(t time.Time) string {
-	return t.Format(time.RFC1123)
-}
-
 func (s *TriggerServer) catnipHeaderHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("oneko-catnip", s.appVersion)
@@ -0,0 +1,564 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/deployment"
+	"o-neko-catnip/pkg/oneko"
+	"o-neko-catnip/pkg/oneko/service"
+	"o-neko-catnip/pkg/policy"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	projectUuid      = "63638583-b9d0-4245-8610-e19c040e6e10"
+	versionUuid      = "5eb9c99f-e1d8-4a70-b394-725de9b4ab0d"
+	otherVersionUuid = "5eb9c99f-e1d8-4a70-b394-725de9b4ab12"
+	otherVersionUrl  = "other-version.oneko.company.cloud"
+	sleepingUuid     = "5eb9c99f-e1d8-4a70-b394-725de9b4ab34"
+	archivedUuid     = "5eb9c99f-e1d8-4a70-b394-725de9b4ab56"
+	archivedUrl      = "archived.oneko.company.cloud"
+	startingUuid     = "5eb9c99f-e1d8-4a70-b394-725de9b4ab78"
+	shopUuid         = "5eb9c99f-e1d8-4a70-b394-725de9b4ab9a"
+	confirmUuid      = "5eb9c99f-e1d8-4a70-b394-725de9b4abbc"
+	groupUuid        = "5eb9c99f-e1d8-4a70-b394-725de9b4abde"
+	// the group member is the version with the same name in another project
+	memberProjectUuid = "63638583-b9d0-4245-8610-e19c040e6e32"
+	memberUuid        = "7a1b1c0e-3c5d-4e0f-9a6b-2d8e4f6a8b10"
+)
+
+var (
+	uut *TriggerServer
+	// deploymentServer is the running deployment of the demo version
+	deploymentServer *countingServer
+	// internalServer stands in for any address reachable from within the cluster
+	internalServer *countingServer
+	// sleepingServer belongs to a version that is not deployed
+	sleepingServer *countingServer
+	// shopServer is the running deployment of a version receiving replayed and proxied requests
+	shopServer *countingServer
+	// confirmServer belongs to a version which is not deployed and only woken up after a confirmation
+	confirmServer *countingServer
+	// startingServer belongs to a deployed version whose pod never becomes ready
+	startingServer *countingServer
+	// groupServer and memberServer belong to the versions of a wake-up group
+	groupServer    *countingServer
+	memberServer   *countingServer
+	deployCalls    atomic.Int32
+	spoolDirectory string
+)
+
+type countingServer struct {
+	*httptest.Server
+	requests atomic.Int32
+	// pending makes the server answer like an ingress without a ready pod
+	pending atomic.Bool
+	// last is the last request other than the probes of the deployment monitor
+	last atomic.Pointer[recordedRequest]
+}
+
+type recordedRequest struct {
+	method     string
+	requestUri string
+	header     http.Header
+	body       string
+	// spooledBodies is the number of request bodies spooled to disk by catnip while the request was received
+	spooledBodies int
+}
+
+func newCountingServer() *countingServer {
+	server := &countingServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.requests.Add(1)
+		if server.pending.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != http.MethodHead {
+			body, _ := io.ReadAll(r.Body)
+			spooled, _ := os.ReadDir(spoolDirectory)
+			server.last.Store(&recordedRequest{method: r.Method, requestUri: r.RequestURI, header: r.Header.Clone(), body: string(body), spooledBodies: len(spooled)})
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	return server
+}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	deploymentServer = newCountingServer()
+	internalServer = newCountingServer()
+	sleepingServer = newCountingServer()
+	shopServer = newCountingServer()
+	confirmServer = newCountingServer()
+	startingServer = newCountingServer()
+	startingServer.pending.Store(true)
+	groupServer = newCountingServer()
+	memberServer = newCountingServer()
+	spoolDirectory, _ = os.MkdirTemp("", "catnip-replay")
+	onekoServer := newFakeOneko(&oneko.Project{
+		Uuid: projectUuid,
+		Name: "Demo Project",
+		Versions: []oneko.ProjectVersion{
+			{
+				Uuid:         versionUuid,
+				Name:         "demoversion",
+				Urls:         []string{deploymentServer.URL},
+				DesiredState: oneko.Deployed,
+			},
+			{
+				Uuid:         otherVersionUuid,
+				Name:         "otherversion",
+				Urls:         []string{otherVersionUrl},
+				DesiredState: oneko.Deployed,
+			},
+			{
+				Uuid:         sleepingUuid,
+				Name:         "sleepingversion",
+				Urls:         []string{sleepingServer.URL},
+				DesiredState: oneko.NotDeployed,
+			},
+			{
+				Uuid:         archivedUuid,
+				Name:         "archive/2023",
+				Urls:         []string{archivedUrl},
+				DesiredState: oneko.NotDeployed,
+			},
+			{
+				Uuid:         shopUuid,
+				Name:         "shopversion",
+				Urls:         []string{shopServer.URL},
+				DesiredState: oneko.Deployed,
+			},
+			{
+				Uuid:         confirmUuid,
+				Name:         "confirm/payment",
+				Urls:         []string{confirmServer.URL},
+				DesiredState: oneko.NotDeployed,
+			},
+			{
+				Uuid:         startingUuid,
+				Name:         "startingversion",
+				Urls:         []string{startingServer.URL},
+				DesiredState: oneko.Deployed,
+			},
+			{
+				Uuid:         groupUuid,
+				Name:         "groupversion",
+				Urls:         []string{groupServer.URL},
+				DesiredState: oneko.Deployed,
+			},
+		},
+	}, &oneko.Project{
+		Uuid: memberProjectUuid,
+		Name: "Member Project",
+		Versions: []oneko.ProjectVersion{
+			{
+				Uuid:         memberUuid,
+				Name:         "groupversion",
+				Urls:         []string{memberServer.URL},
+				DesiredState: oneko.Deployed,
+			},
+		},
+	})
+
+	config.OverrideConfiguration(&config.Config{
+		ONeko: config.ONekoConfig{
+			Api: config.ApiConfig{
+				BaseUrl: onekoServer.URL,
+				Auth: config.AuthConfig{
+					Username: "admin",
+					Password: "s3cr3t",
+				},
+				ApiCallCacheDuration: 15 * time.Second,
+				IndexRefreshInterval: time.Minute,
+			},
+			CatnipUrl: "catnip.example.com",
+			Routing: config.RoutingConfig{
+				HostPatterns: []config.HostPatternConfig{
+					{Glob: "*.preview.oneko.company.cloud"},
+					{Regex: `(?P<versionName>[a-z]+)\.(?P<projectId>[0-9a-f-]+)\.apps\.oneko\.company\.cloud`},
+				},
+			},
+			Mode: "production",
+			Server: config.ServerConfig{
+				Port: 8090,
+			},
+			Logging: config.LoggingConfig{
+				Level: "error",
+			},
+			Replay: config.ReplayConfig{
+				Enabled:           true,
+				MaxBodySize:       64,
+				MaxMemoryBodySize: 16,
+				SpoolDirectory:    spoolDirectory,
+				Timeout:           100 * time.Millisecond,
+			},
+			Bots: config.BotsConfig{
+				Enabled:           true,
+				UserAgentPatterns: []string{`^InternalLinkChecker/`},
+			},
+			Policy: config.PolicyConfig{
+				AdminToken: "let-me-in",
+			},
+			Capacity: config.CapacityConfig{
+				RefreshInterval: time.Minute,
+			},
+			History: config.HistoryConfig{
+				Samples: 10,
+				MaxWait: 30 * time.Minute,
+			},
+			Groups: []config.GroupConfig{{
+				Name:     "checkout",
+				Projects: []string{"Demo Project", "Member Project"},
+			}},
+			Rules: []config.RuleConfig{{
+				Versions: []string{"archive/*"},
+				Action:   "deny",
+				Reason:   "Archived versions are kept for reference only.",
+				Contact:  "the platform team",
+			}, {
+				Versions: []string{"confirm/*"},
+				Action:   "confirm",
+			}},
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	uut = New(config.Configuration(), ctx, "test")
+	// the projects are loaded in the background
+	for deadline := time.Now().Add(5 * time.Second); len(uut.oneko.GetAllProjects()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	code := m.Run()
+
+	cancel()
+	onekoServer.Close()
+	deploymentServer.Close()
+	internalServer.Close()
+	sleepingServer.Close()
+	shopServer.Close()
+	confirmServer.Close()
+	startingServer.Close()
+	groupServer.Close()
+	memberServer.Close()
+	_ = os.RemoveAll(spoolDirectory)
+	os.Exit(code)
+}
+
+func newFakeOneko(projects ...*oneko.Project) *httptest.Server {
+	byPath := make(map[string]*oneko.Project, len(projects))
+	for _, project := range projects {
+		byPath["/api/project/"+project.Uuid] = project
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch project, known := byPath[r.URL.Path]; {
+		case r.URL.Path == "/api/session":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/api/project":
+			_ = json.NewEncoder(w).Encode(projects)
+		case known:
+			_ = json.NewEncoder(w).Encode(project)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/deploy"):
+			deployCalls.Add(1)
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func requestStatus(deploymentUrl string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/status?deploymentUrl="+url.QueryEscape(deploymentUrl), nil)
+	uut.handleStatusRequest(c)
+	return recorder
+}
+
+func requestWakeup(deploymentUrl string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/wakeup?deploymentUrl="+url.QueryEscape(deploymentUrl), nil)
+	uut.handleWakeupRequest(c)
+	return recorder
+}
+
+func Test_GetRedirectUrl_KeepsTheCompleteRequestUri(t *testing.T) {
+	s := &TriggerServer{
+		configuration: &config.Config{
+			ONeko: config.ONekoConfig{
+				CatnipUrl: "catnip.example.com",
+			},
+		},
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/cart/a&b?tab=checkout&user=42", nil)
+	c.Request.Host = "shop.preview.example.com"
+
+	redirectUrl := s.getRedirectUrl(&oneko.Project{Uuid: "project-uuid"}, &oneko.ProjectVersion{Uuid: "version-uuid"}, c)
+
+	parsed, err := url.Parse(redirectUrl)
+	assert.NoError(t, err)
+	assert.Equal(t, "http", parsed.Scheme)
+	assert.Equal(t, "catnip.example.com", parsed.Host)
+	assert.Equal(t, "/wakeup", parsed.Path)
+	assert.Equal(t, "project-uuid", parsed.Query().Get("projectId"))
+	assert.Equal(t, "version-uuid", parsed.Query().Get("versionId"))
+	assert.Equal(t, "http://shop.preview.example.com/cart/a&b?tab=checkout&user=42", parsed.Query().Get("redirectTo"))
+}
+
+func Test_StatusRequest_ProbesKnownDeployments(t *testing.T) {
+	requestsBefore := deploymentServer.requests.Load()
+
+	recorder := requestStatus(deploymentServer.URL + "/some/page?tab=checkout")
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var status deployment.StatusResponse
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, deployment.Ready, status.DeploymentStatus)
+	assert.Equal(t, deploymentServer.URL+"/some/page?tab=checkout", status.RedirectUrl)
+	assert.Greater(t, deploymentServer.requests.Load(), requestsBefore)
+
+	var identity versionIdentity
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &identity))
+	assert.Equal(t, projectUuid, identity.ProjectUuid)
+	assert.Equal(t, versionUuid, identity.VersionUuid)
+	assert.Equal(t, "demoversion", identity.VersionName)
+}
+
+func Test_StatusRequest_DoesNotTriggerDeployments(t *testing.T) {
+	deployCallsBefore := deployCalls.Load()
+
+	recorder := requestStatus(sleepingServer.URL)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, deployCallsBefore, deployCalls.Load())
+}
+
+func Test_WakeupRequest_TriggersSleepingDeployments(t *testing.T) {
+	deployCallsBefore := deployCalls.Load()
+
+	recorder := requestWakeup(sleepingServer.URL + "/cart")
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	var response wakeupResponse
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.True(t, response.Triggered)
+	assert.Equal(t, sleepingUuid, response.VersionUuid)
+	assert.Equal(t, deployCallsBefore+1, deployCalls.Load())
+}
+
+func Test_WakeupPage_OnlyTriggersDeploymentsWhenPosted(t *testing.T) {
+	templates, err := loadTemplates(os.DirFS("../../frontend"), nil, uut.templateFuncs())
+	assert.NoError(t, err)
+	requestWakeupPage := func(method string, body io.Reader) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, engine := gin.CreateTestContext(recorder)
+		engine.SetHTMLTemplate(templates)
+		query := url.Values{"projectId": {projectUuid}, "versionId": {sleepingUuid}, "redirectTo": {sleepingServer.URL + "/cart"}}
+		c.Request = httptest.NewRequest(method, "/wakeup?"+query.Encode(), body)
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		c.Request.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "abc123"})
+		if method == http.MethodGet {
+			uut.handleGetRequestToWakeupUrl(c)
+		} else {
+			uut.handlePostRequestToWakeupUrl(c)
+		}
+		return recorder
+	}
+	deployCallsBefore := deployCalls.Load()
+
+	// the page wakes the deployment up through the API once it has been loaded
+	recorder := requestWakeupPage(http.MethodGet, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `x-data="wakeup"`)
+	assert.Equal(t, deployCallsBefore, deployCalls.Load())
+
+	recorder = requestWakeupPage(http.MethodPost, strings.NewReader(csrfFormField+"=abc123"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, deployCallsBefore+1, deployCalls.Load())
+}
+
+func Test_WakeupRequest_IgnoresDeployedVersions(t *testing.T) {
+	deployCallsBefore := deployCalls.Load()
+
+	recorder := requestWakeup(deploymentServer.URL)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response wakeupResponse
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.False(t, response.Triggered)
+	assert.Equal(t, deployCallsBefore, deployCalls.Load())
+}
+
+func Test_WakeupRequest_RespectsThePolicy(t *testing.T) {
+	// a window starting in an hour never includes the current time
+	start := time.Now().UTC().Add(time.Hour)
+	restrictive, err := policy.New(config.PolicyConfig{
+		Windows: []config.WakeupWindowConfig{{
+			From: start.Format("15:04"),
+			To:   start.Add(time.Hour).Format("15:04"),
+		}},
+	})
+	assert.NoError(t, err)
+	defaultPolicy := uut.policy
+	uut.policy = restrictive
+	defer func() { uut.policy = defaultPolicy }()
+	deployCallsBefore := deployCalls.Load()
+
+	recorder := requestWakeup(sleepingServer.URL)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+	var response policyViolationResponse
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.True(t, response.NextAllowedWakeup.After(time.Now()))
+	assert.Equal(t, deployCallsBefore, deployCalls.Load())
+
+	recorder = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/wakeup?deploymentUrl="+url.QueryEscape(sleepingServer.URL), nil)
+	c.Request.Header.Set(adminTokenHeader, "let-me-in")
+	uut.handleWakeupRequest(c)
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Equal(t, deployCallsBefore+1, deployCalls.Load())
+}
+
+func Test_SetRetryAfter_RoundsUpToFullSeconds(t *testing.T) {
+	retryAfter := func(decision policy.Decision) string {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		setRetryAfter(decision, c)
+		return recorder.Header().Get("Retry-After")
+	}
+	// the next allowed wake-up is only used to tell whether there is one, the policy computed the time until then
+	nextAllowed := time.Date(2024, 3, 6, 7, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, "3600", retryAfter(policy.Decision{NextAllowed: nextAllowed, RetryAfter: time.Hour}))
+	assert.Equal(t, "91", retryAfter(policy.Decision{NextAllowed: nextAllowed, RetryAfter: 90*time.Second + time.Millisecond}))
+	assert.Equal(t, "1", retryAfter(policy.Decision{NextAllowed: nextAllowed}))
+	assert.Empty(t, retryAfter(policy.Decision{Allowed: true}))
+}
+
+func Test_WakeupRequest_RespectsTheRules(t *testing.T) {
+	deployCallsBefore := deployCalls.Load()
+
+	recorder := requestWakeup("http://" + archivedUrl)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	var response ruleViolationResponse
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, "Archived versions are kept for reference only.", response.Reason)
+	assert.Equal(t, "the platform team", response.Contact)
+
+	// the admin token does not bypass the rules, nor does triggering the deployment directly
+	recorder = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/wakeup?deploymentUrl="+url.QueryEscape("http://"+archivedUrl), nil)
+	c.Request.Header.Set(adminTokenHeader, "let-me-in")
+	uut.handleWakeupRequest(c)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.ErrorIs(t, uut.oneko.TriggerDeployment(projectUuid, archivedUuid, context.Background()), service.ErrDeniedByRule)
+
+	assert.Equal(t, deployCallsBefore, deployCalls.Load())
+}
+
+func Test_WakeupRequest_RejectsUnknownUrls(t *testing.T) {
+	recorder := requestWakeup(internalServer.URL)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, int32(0), internalServer.requests.Load())
+}
+
+func Test_StatusRequest_DoesNotProbeUnknownAddresses(t *testing.T) {
+	deploymentHost := strings.TrimPrefix(deploymentServer.URL, "http://")
+	internalHost := strings.TrimPrefix(internalServer.URL, "http://")
+
+	for _, deploymentUrl := range []string{
+		internalServer.URL,
+		internalServer.URL + "/admin",
+		internalServer.URL + "/#" + deploymentHost,
+		internalServer.URL + "/?" + deploymentHost,
+		"http://" + deploymentHost + "@" + internalHost + "/",
+		"http://user:password@" + deploymentHost + "/",
+		"ftp://" + deploymentHost,
+		"file:///etc/passwd",
+		"http://169.254.169.254/latest/meta-data/",
+		"",
+	} {
+		recorder := requestStatus(deploymentUrl)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, deploymentUrl)
+		assert.Contains(t, recorder.Body.String(), "error", deploymentUrl)
+	}
+
+	assert.Equal(t, int32(0), internalServer.requests.Load())
+}
+
+func Test_ValidateRedirectTarget(t *testing.T) {
+	project, version, err := uut.oneko.GetProjectAndVersionByIds(projectUuid, versionUuid)
+	assert.NoError(t, err)
+
+	assert.NoError(t, uut.validateRedirectTarget(deploymentServer.URL+"/cart?tab=checkout#top", project, version))
+
+	assert.Error(t, uut.validateRedirectTarget("https://evil.example.com/", project, version))
+	assert.Error(t, uut.validateRedirectTarget("javascript:alert(1)", project, version))
+	assert.Error(t, uut.validateRedirectTarget("//evil.example.com", project, version))
+	assert.Error(t, uut.validateRedirectTarget(internalServer.URL, project, version))
+	// URLs of other versions are rejected as well
+	assert.Error(t, uut.validateRedirectTarget("http://"+otherVersionUrl+"/", project, version))
+}
+
+func Test_IsValidCsrfToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		cookieToken string
+		formToken   string
+		want        bool
+	}{
+		{"matching tokens", "abc123", "abc123", true},
+		{"mismatching tokens", "abc123", "def456", false},
+		{"missing form token", "abc123", "", false},
+		{"missing cookie", "", "abc123", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{csrfFormField: {tt.formToken}}
+			request := httptest.NewRequest(http.MethodPost, "/wakeup", strings.NewReader(form.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if len(tt.cookieToken) > 0 {
+				request.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookieToken})
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = request
+			assert.Equal(t, tt.want, isValidCsrfToken(c))
+		})
+	}
+}
+
+func Test_GetGroupStatus_WaitsForRequiredMembers(t *testing.T) {
+	ready := &deployment.StatusResponse{DeploymentStatus: deployment.Ready}
+	member := func(status deployment.DeploymentStatus, required bool) memberStatus {
+		return memberStatus{StatusResponse: deployment.StatusResponse{DeploymentStatus: status}, Required: required}
+	}
+
+	assert.Equal(t, deployment.Ready, getGroupStatus(ready, nil).DeploymentStatus)
+	assert.Equal(t, deployment.Ready, getGroupStatus(ready, []memberStatus{member(deployment.Ready, true), member(deployment.Pending, false)}).DeploymentStatus)
+	assert.Equal(t, deployment.Pending, getGroupStatus(ready, []memberStatus{member(deployment.Pending, true)}).DeploymentStatus)
+	assert.Equal(t, deployment.Error, getGroupStatus(ready, []memberStatus{member(deployment.Pending, true), member(deployment.Error, true)}).DeploymentStatus)
+	assert.Equal(t, deployment.Pending, getGroupStatus(&deployment.StatusResponse{DeploymentStatus: deployment.Pending}, []memberStatus{member(deployment.Ready, true)}).DeploymentStatus)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"o-neko-catnip/frontend"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hashedAssetPattern matches the names vite gives to the files it builds, e.g. wakeup-BzX3k1aQ.js. Their content never
+// changes, a new build uses new names instead. Files of a theme may be named alike but are replaced in place.
+var hashedAssetPattern = regexp.MustCompile(`-[A-Za-z0-9_-]{8}\.[a-z0-9]+$`)
+
+const (
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	revalidateCacheControl = "no-cache"
+)
+
+// precompressedVariants are looked for next to a file in the order of preference.
+var precompressedVariants = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// staticFiles serves files from the first of its layers containing them, e.g. a theme before the built-in frontend.
+// Brotli or gzip compressed variants of a file in the same layer are preferred if the client accepts them. Responses
+// carry strong ETags, the ones of immutable layers are computed only once.
+type staticFiles struct {
+	layers []staticLayer
+	etags  sync.Map
+}
+
+type staticLayer struct {
+	files     fs.FS
+	immutable bool
+}
+
+func newStaticFiles(layers ...staticLayer) *staticFiles {
+	present := make([]staticLayer, 0, len(layers))
+	for _, layer := range layers {
+		if layer.files != nil {
+			present = append(present, layer)
+		}
+	}
+	return &staticFiles{layers: present}
+}
+
+// handler serves the file named by the path parameter.
+func (f *staticFiles) handler(parameter string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f.serve(c, c.Param(parameter))
+	}
+}
+
+func (f *staticFiles) serve(c *gin.Context, name string) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	for i, layer := range f.layers {
+		if !isFile(layer.files, name) {
+			continue
+		}
+		candidate, encoding := name, ""
+		for _, variant := range precompressedVariants {
+			if acceptsEncoding(c.GetHeader("Accept-Encoding"), variant.encoding) && isFile(layer.files, name+variant.extension) {
+				candidate, encoding = name+variant.extension, variant.encoding
+				break
+			}
+		}
+		content, err := fs.ReadFile(layer.files, candidate)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Header("Vary", "Accept-Encoding")
+		c.Header("ETag", f.etag(i, layer, candidate, content))
+		if layer.immutable && hashedAssetPattern.MatchString(name) {
+			c.Header("Cache-Control", immutableCacheControl)
+		} else {
+			c.Header("Cache-Control", revalidateCacheControl)
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+		c.Header("Content-Type", contentType)
+		if len(encoding) > 0 {
+			c.Header("Content-Encoding", encoding)
+		}
+		http.ServeContent(c.Writer, c.Request, name, time.Time{}, bytes.NewReader(content))
+		return
+	}
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (f *staticFiles) etag(layerIndex int, layer staticLayer, name string, content []byte) string {
+	if !layer.immutable {
+		return computeEtag(content)
+	}
+	key := [2]any{layerIndex, name}
+	if etag, ok := f.etags.Load(key); ok {
+		return etag.(string)
+	}
+	etag := computeEtag(content)
+	f.etags.Store(key, etag)
+	return etag
+}
+
+func computeEtag(content []byte) string {
+	hash := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+func isFile(fsys fs.FS, name string) bool {
+	info, err := fs.Stat(fsys, name)
+	return err == nil && !info.IsDir()
+}
+
+// acceptsEncoding checks the Accept-Encoding header for the encoding without a quality of zero.
+func acceptsEncoding(acceptEncoding string, encoding string) bool {
+	for _, accepted := range strings.Split(acceptEncoding, ",") {
+		name, parameters, _ := strings.Cut(accepted, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		quality := strings.ReplaceAll(strings.TrimSpace(parameters), " ", "")
+		return !(quality == "q=0" || quality == "q=0.0" || quality == "q=0.00" || quality == "q=0.000")
+	}
+	return false
+}
+
+// builtinFrontend returns the frontend on disk if it is configured, otherwise the one embedded into release builds.
+// Other builds fall back to the frontend built in the working directory. The frontend on disk is live, so neither its
+// assets nor its templates may be cached.
+func (s *TriggerServer) builtinFrontend() (fsys fs.FS, live bool) {
+	if directory := s.configuration.ONeko.Server.FrontendDirectory; len(directory) > 0 {
+		return os.DirFS(directory), true
+	}
+	if embedded := frontend.Dist(); embedded != nil {
+		return embedded, false
+	}
+	return os.DirFS("frontend/dist"), true
+}
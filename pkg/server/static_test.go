@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveStatic(files *staticFiles, name string, headers map[string]string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/assets/"+name, nil)
+	for header, value := range headers {
+		c.Request.Header.Set(header, value)
+	}
+	files.serve(c, name)
+	c.Writer.WriteHeaderNow()
+	return recorder
+}
+
+func Test_StaticFiles_SendsStrongEtagsAndCacheHeaders(t *testing.T) {
+	files := newStaticFiles(staticLayer{files: fstest.MapFS{
+		"wakeup-BzX3k1aQ.js": {Data: []byte("console.log('wakeup')")},
+		"oneko.svg":          {Data: []byte("<svg></svg>")},
+	}, immutable: true})
+
+	response := serveStatic(files, "wakeup-BzX3k1aQ.js", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "console.log('wakeup')", response.Body.String())
+	assert.Equal(t, immutableCacheControl, response.Header().Get("Cache-Control"))
+	assert.Equal(t, "text/javascript; charset=utf-8", response.Header().Get("Content-Type"))
+	etag := response.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	response = serveStatic(files, "wakeup-BzX3k1aQ.js", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, response.Code)
+	assert.Empty(t, response.Body.String())
+
+	// files without a content hash in their name may change with the next release
+	response = serveStatic(files, "oneko.svg", nil)
+	assert.Equal(t, revalidateCacheControl, response.Header().Get("Cache-Control"))
+	assert.NotEqual(t, etag, response.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusNotFound, serveStatic(files, "missing.js", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveStatic(files, "../server.go", nil).Code)
+}
+
+func Test_StaticFiles_PrefersPrecompressedVariants(t *testing.T) {
+	files := newStaticFiles(staticLayer{files: fstest.MapFS{
+		"index-C4a1Xq9z.css":    {Data: []byte("body{}")},
+		"index-C4a1Xq9z.css.gz": {Data: []byte("gzipped")},
+		"index-C4a1Xq9z.css.br": {Data: []byte("brotli")},
+	}, immutable: true})
+
+	response := serveStatic(files, "index-C4a1Xq9z.css", map[string]string{"Accept-Encoding": "gzip, deflate, br"})
+	assert.Equal(t, "brotli", response.Body.String())
+	assert.Equal(t, "br", response.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/css; charset=utf-8", response.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept-Encoding", response.Header().Get("Vary"))
+	brotliEtag := response.Header().Get("ETag")
+
+	response = serveStatic(files, "index-C4a1Xq9z.css", map[string]string{"Accept-Encoding": "gzip, br;q=0"})
+	assert.Equal(t, "gzipped", response.Body.String())
+	assert.Equal(t, "gzip", response.Header().Get("Content-Encoding"))
+	assert.NotEqual(t, brotliEtag, response.Header().Get("ETag"))
+
+	response = serveStatic(files, "index-C4a1Xq9z.css", nil)
+	assert.Equal(t, "body{}", response.Body.String())
+	assert.Empty(t, response.Header().Get("Content-Encoding"))
+}
+
+func Test_StaticFiles_PrefersTheTheme(t *testing.T) {
+	files := newStaticFiles(
+		staticLayer{files: fstest.MapFS{"oneko.svg": {Data: []byte("themed logo")}}},
+		staticLayer{files: nil},
+		staticLayer{files: fstest.MapFS{
+			"oneko.svg":    {Data: []byte("built-in logo")},
+			"oneko.svg.gz": {Data: []byte("gzipped built-in logo")},
+			"favicon.ico":  {Data: []byte("icon")},
+		}, immutable: true},
+	)
+
+	// compressed variants of the built-in file must not replace the themed one
+	response := serveStatic(files, "oneko.svg", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, "themed logo", response.Body.String())
+	assert.Empty(t, response.Header().Get("Content-Encoding"))
+	assert.Equal(t, "icon", serveStatic(files, "favicon.ico", nil).Body.String())
+}
+
+func Test_StaticFiles_RevalidatesFilesOfMutableLayers(t *testing.T) {
+	files := newStaticFiles(
+		staticLayer{files: fstest.MapFS{"brand-2024Q1ab.css": {Data: []byte("themed")}}},
+		staticLayer{files: fstest.MapFS{"wakeup-BzX3k1aQ.js": {Data: []byte("built-in")}}, immutable: true},
+	)
+
+	// themes are replaced in place, even if their files are named like the ones built by vite
+	assert.Equal(t, revalidateCacheControl, serveStatic(files, "brand-2024Q1ab.css", nil).Header().Get("Cache-Control"))
+	assert.Equal(t, immutableCacheControl, serveStatic(files, "wakeup-BzX3k1aQ.js", nil).Header().Get("Cache-Control"))
+}
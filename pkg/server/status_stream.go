@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"o-neko-catnip/pkg/deployment"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	statusStreamKeepAliveInterval = 15 * time.Second
+	queuePositionCheckInterval    = 2 * time.Second
+)
+
+// handleStatusStreamRequest pushes the status of a deployment as server-sent events whenever it changes. Like
+// handleStatusRequest it never starts a deployment. Changes of the position in the wake-up queue and of the status of
+// other members of the version's groups are pushed as well.
+func (s *TriggerServer) handleStatusStreamRequest(c *gin.Context) {
+	deploymentUrl, exists := c.GetQuery("deploymentUrl")
+	if !exists {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	match, err := s.oneko.MatchDeploymentUrl(deploymentUrl)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	identity := newVersionIdentity(match.Project, match.Version)
+	members := s.getGroupMembersWithProbeUrls(getProtocolOfUrl(deploymentUrl), match)
+
+	// the updates of all members are merged, so the group status can be pushed whenever one of them changes
+	ctx := c.Request.Context()
+	updates := make(chan memberUpdate)
+	for i, member := range members {
+		memberUpdates, unsubscribe := s.monitor.Subscribe(member.probeUrl)
+		defer unsubscribe()
+		go forwardMemberUpdates(ctx, i, memberUpdates, updates)
+	}
+
+	keepAlive := time.NewTicker(statusStreamKeepAliveInterval)
+	defer keepAlive.Stop()
+	queueCheck := time.NewTicker(queuePositionCheckInterval)
+	defer queueCheck.Stop()
+
+	statuses := make([]*deployment.StatusResponse, len(members))
+	sendStatus := func(queuePosition int) {
+		memberStatuses := s.getMemberStatuses(members[1:], statuses[1:])
+		response := statusResponse{
+			StatusResponse:  getGroupStatus(statuses[0], memberStatuses),
+			versionIdentity: identity,
+			QueuePosition:   queuePosition,
+			Stale:           match.Stale,
+			Members:         memberStatuses,
+		}
+		response.RedirectUrl = deploymentUrl
+		response.EstimatedReadyAt = s.estimateReadyAt(response.DeploymentStatus, identity.VersionUuid)
+		c.SSEvent("status", response)
+	}
+	lastQueuePosition := s.capacity.QueuePosition(match.Version.Uuid)
+
+	// the headers are flushed before the first event if a member reports its status first
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// disables response buffering in nginx based ingresses
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-queueCheck.C:
+			queuePosition := s.capacity.QueuePosition(match.Version.Uuid)
+			if queuePosition != lastQueuePosition && statuses[0] != nil {
+				sendStatus(queuePosition)
+			}
+			lastQueuePosition = queuePosition
+			return true
+		case update := <-updates:
+			statuses[update.member] = update.status
+			// nothing is pushed before the status of the requested version is known
+			if statuses[0] != nil {
+				sendStatus(lastQueuePosition)
+			}
+			return true
+		}
+	})
+}
+
+type memberUpdate struct {
+	member int
+	status *deployment.StatusResponse
+}
+
+func forwardMemberUpdates(ctx context.Context, member int, from <-chan *deployment.StatusResponse, to chan<- memberUpdate) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case status := <-from:
+			select {
+			case <-ctx.Done():
+				return
+			case to <- memberUpdate{member: member, status: status}:
+			}
+		}
+	}
+}
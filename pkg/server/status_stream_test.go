@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"o-neko-catnip/pkg/deployment"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// useFastMonitor checks deployments more often than the default monitor.
+func useFastMonitor(t *testing.T) {
+	monitor := uut.monitor
+	uut.monitor = deployment.NewWithIntervals(10*time.Millisecond, 10*time.Millisecond)
+	t.Cleanup(func() {
+		uut.monitor = monitor
+	})
+}
+
+// openStatusStream requests the status stream of the deployment from a real server, so the stream can be read while it
+// is written. The client disconnects once the test is done or the context is cancelled. The returned channel is closed
+// once the handler returned.
+func openStatusStream(t *testing.T, ctx context.Context, deploymentUrl string) (<-chan statusResponse, <-chan struct{}) {
+	handlerDone := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(handlerDone)
+		c, _ := gin.CreateTestContext(w)
+		c.Request = r
+		uut.handleStatusStreamRequest(c)
+	}))
+	t.Cleanup(server.Close)
+	// cleanups run in reverse order, so the stream is closed before the server waits for its handler
+	ctx, disconnect := context.WithCancel(ctx)
+	t.Cleanup(disconnect)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/status/stream?deploymentUrl="+url.QueryEscape(deploymentUrl), nil)
+	assert.NoError(t, err)
+	response, err := http.DefaultClient.Do(request)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, response.Header.Get("Content-Type"), "text/event-stream")
+
+	events := make(chan statusResponse, 16)
+	go func() {
+		defer close(events)
+		defer response.Body.Close()
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			data, isData := strings.CutPrefix(scanner.Text(), "data:")
+			if !isData {
+				continue
+			}
+			var event statusResponse
+			if err := json.Unmarshal([]byte(data), &event); err == nil {
+				events <- event
+			}
+		}
+	}()
+	return events, handlerDone
+}
+
+func nextStatusEvent(t *testing.T, events <-chan statusResponse) statusResponse {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("the status stream has been closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no status event has been sent")
+	}
+	return statusResponse{}
+}
+
+func Test_StatusStream_SendsTheStatusUntilTheDeploymentIsReady(t *testing.T) {
+	useFastMonitor(t)
+	sleepingServer.pending.Store(true)
+	t.Cleanup(func() { sleepingServer.pending.Store(false) })
+	deployCallsBefore := deployCalls.Load()
+
+	events, _ := openStatusStream(t, context.Background(), sleepingServer.URL+"/cart")
+
+	event := nextStatusEvent(t, events)
+	assert.Equal(t, deployment.Pending, event.DeploymentStatus)
+	assert.Equal(t, sleepingUuid, event.VersionUuid)
+	assert.Equal(t, sleepingServer.URL+"/cart", event.RedirectUrl)
+
+	sleepingServer.pending.Store(false)
+	event = nextStatusEvent(t, events)
+	assert.Equal(t, deployment.Ready, event.DeploymentStatus)
+	assert.Equal(t, sleepingServer.URL+"/cart", event.RedirectUrl)
+	// the stream only reports the status
+	assert.Equal(t, deployCallsBefore, deployCalls.Load())
+}
+
+func Test_StatusStream_WaitsForTheMembersOfTheGroup(t *testing.T) {
+	useFastMonitor(t)
+	groupServer.pending.Store(true)
+	memberServer.pending.Store(true)
+	t.Cleanup(func() {
+		groupServer.pending.Store(false)
+		memberServer.pending.Store(false)
+	})
+
+	events, _ := openStatusStream(t, context.Background(), groupServer.URL)
+
+	event := nextStatusEvent(t, events)
+	assert.Equal(t, deployment.Pending, event.DeploymentStatus)
+	if assert.Len(t, event.Members, 1) {
+		assert.Equal(t, memberUuid, event.Members[0].VersionUuid)
+		assert.True(t, event.Members[0].Required)
+	}
+
+	// the version itself is ready, but its required member is not
+	groupServer.pending.Store(false)
+	time.Sleep(100 * time.Millisecond)
+	for drained := false; !drained; {
+		select {
+		case event := <-events:
+			assert.Equal(t, deployment.Pending, event.DeploymentStatus)
+		default:
+			drained = true
+		}
+	}
+
+	memberServer.pending.Store(false)
+	event = nextStatusEvent(t, events)
+	assert.Equal(t, deployment.Ready, event.DeploymentStatus)
+	if assert.Len(t, event.Members, 1) {
+		assert.Equal(t, deployment.Ready, event.Members[0].DeploymentStatus)
+	}
+}
+
+func Test_StatusStream_StopsWhenTheClientDisconnects(t *testing.T) {
+	useFastMonitor(t)
+	startingServer.pending.Store(true)
+	ctx, disconnect := context.WithCancel(context.Background())
+
+	events, handlerDone := openStatusStream(t, ctx, startingServer.URL)
+	assert.Equal(t, deployment.Pending, nextStatusEvent(t, events).DeploymentStatus)
+	disconnect()
+
+	select {
+	case <-handlerDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler kept streaming after the client disconnected")
+	}
+	// nobody is interested in the deployment anymore, so it is not checked anymore either
+	time.Sleep(50 * time.Millisecond)
+	probesAfterDisconnect := startingServer.requests.Load()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, probesAfterDisconnect, startingServer.requests.Load())
+}
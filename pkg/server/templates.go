@@ -0,0 +1,246 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/i18n"
+	"o-neko-catnip/pkg/oneko"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+type templateParameters struct {
+	Project    oneko.Project
+	Version    oneko.ProjectVersion
+	BaseUrl    string
+	CsrfToken  string
+	NextWakeup time.Time
+	// Reason and Contact explain why a version must not be woken up and whom to ask about it
+	Reason  string
+	Contact string
+	// QueuePosition is the position of the version in the wake-up queue, zero if its deployment has been triggered
+	QueuePosition int
+	Error         string
+	// DeploymentUrl is the URL the user is sent to once the deployment is ready, if it is known
+	DeploymentUrl string
+	CatnipUrl     string
+	CatnipVersion string
+	Now           time.Time
+	// Language is the language the page is rendered in
+	Language string
+
+	catalogs   *i18n.Catalogs
+	requestUrl url.URL
+}
+
+// languageLink switches the page to another language.
+type languageLink struct {
+	Language string
+	Name     string
+	Url      string
+	Active   bool
+}
+
+// newTemplateParameters fills in the data available to all templates. Project and version may be nil.
+func (s *TriggerServer) newTemplateParameters(c *gin.Context, project *oneko.Project, version *oneko.ProjectVersion) templateParameters {
+	parameters := templateParameters{
+		BaseUrl:       s.configuration.ONeko.Api.BaseUrl,
+		CatnipUrl:     s.configuration.ONeko.CatnipUrl,
+		CatnipVersion: s.appVersion,
+		Now:           time.Now(),
+		Language:      s.language(c),
+		catalogs:      s.i18n,
+		requestUrl:    *c.Request.URL,
+	}
+	if project != nil {
+		parameters.Project = *project
+	}
+	if version != nil {
+		parameters.Version = *version
+	}
+	return parameters
+}
+
+// T translates the message into the language of the page. Messages may contain HTML, string arguments are escaped.
+func (p templateParameters) T(key string, args ...any) template.HTML {
+	escaped := make([]any, len(args))
+	for i, arg := range args {
+		switch value := arg.(type) {
+		case string:
+			escaped[i] = template.HTMLEscapeString(value)
+		case error:
+			escaped[i] = template.HTMLEscapeString(value.Error())
+		default:
+			escaped[i] = value
+		}
+	}
+	return template.HTML(p.catalogs.Translate(p.Language, key, escaped...))
+}
+
+// Text translates the message like T, but as plain text, e.g. for titles and attributes.
+func (p templateParameters) Text(key string, args ...any) string {
+	return p.catalogs.Translate(p.Language, key, args...)
+}
+
+func (p templateParameters) FormatAsDate(t time.Time) string {
+	return p.catalogs.FormatDate(p.Language, t)
+}
+
+func (p templateParameters) FormatAsRelative(t time.Time) string {
+	return p.catalogs.FormatRelative(p.Language, t)
+}
+
+func (p templateParameters) FormatDuration(d time.Duration) string {
+	return p.catalogs.FormatDuration(p.Language, d)
+}
+
+// LanguageLinks links the current page in all languages, it is empty if there is only one.
+func (p templateParameters) LanguageLinks() []languageLink {
+	languages := p.catalogs.Languages()
+	if len(languages) < 2 {
+		return nil
+	}
+	links := make([]languageLink, len(languages))
+	for i, lang := range languages {
+		target := p.requestUrl
+		query := target.Query()
+		query.Set(languageQueryParameter, lang)
+		target.RawQuery = query.Encode()
+		links[i] = languageLink{
+			Language: lang,
+			Name:     p.catalogs.Translate(lang, "language.name"),
+			Url:      target.RequestURI(),
+			Active:   lang == p.Language,
+		}
+	}
+	return links
+}
+
+// templateFuncs are the functions available to the built-in templates and the templates of themes. The formatting
+// functions use the default language, the methods of the parameters the language of the page.
+func (s *TriggerServer) templateFuncs() template.FuncMap {
+	defaultLanguage := s.i18n.DefaultLanguage()
+	return template.FuncMap{
+		"formatAsDate": func(t time.Time) string {
+			return s.i18n.FormatDate(defaultLanguage, t)
+		},
+		"formatAsRelative": func(t time.Time) string {
+			return s.i18n.FormatRelative(defaultLanguage, t)
+		},
+		"formatDuration": func(d time.Duration) string {
+			return s.i18n.FormatDuration(defaultLanguage, d)
+		},
+		"onekoUrl": func(segments ...string) (string, error) {
+			return joinUrl(s.configuration.ONeko.Api.BaseUrl, segments...)
+		},
+		"joinUrl":      joinUrl,
+		"addQuery":     addQuery,
+		"versionBadge": versionBadge,
+		"projectBadge": projectBadge,
+	}
+}
+
+// versionBadge highlights the name of a version within a translated message.
+func versionBadge(name string) template.HTML {
+	return template.HTML(`<span class="version-badge">` + template.HTMLEscapeString(name) + `</span>`)
+}
+
+// projectBadge highlights the name of a project within a translated message.
+func projectBadge(name string) template.HTML {
+	return template.HTML(`<span class="project-badge">` + template.HTMLEscapeString(name) + `</span>`)
+}
+
+// joinUrl appends the escaped path segments to the base URL.
+func joinUrl(base string, segments ...string) (string, error) {
+	return url.JoinPath(base, segments...)
+}
+
+// addQuery adds pairs of query parameter names and values to the URL.
+func addQuery(rawUrl string, pairs ...string) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", errors.New("addQuery expects pairs of names and values")
+	}
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	for i := 0; i < len(pairs); i += 2 {
+		query.Add(pairs[i], pairs[i+1])
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// loadTemplates parses the templates of the built-in frontend. Templates of the theme replace the built-in ones with
+// the same name, others can be used as partials. The theme is optional.
+func loadTemplates(builtin fs.FS, theme fs.FS, funcs template.FuncMap) (*template.Template, error) {
+	sources := make(map[string]fs.FS)
+	for _, fsys := range []fs.FS{builtin, theme} {
+		if fsys == nil {
+			continue
+		}
+		names, err := fs.Glob(fsys, "*.html")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			sources[name] = fsys
+		}
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no templates found")
+	}
+
+	templates := template.New("").Funcs(funcs)
+	for name, fsys := range sources {
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := templates.New(name).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+	}
+	return templates, nil
+}
+
+// newThemeFS returns the directory of the theme, nil if there is none.
+func newThemeFS(c config.ThemeConfig) fs.FS {
+	if len(c.Directory) == 0 {
+		return nil
+	}
+	return os.DirFS(c.Directory)
+}
+
+// subFS returns the subdirectory of the file system, nil if there is no file system.
+func subFS(fsys fs.FS, dir string) fs.FS {
+	if fsys == nil {
+		return nil
+	}
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		return nil
+	}
+	return sub
+}
+
+// reloadingRender parses the templates for every page it renders, so changes to them are visible right away.
+type reloadingRender struct {
+	load func() (*template.Template, error)
+}
+
+func (r reloadingRender) Instance(name string, data any) render.Render {
+	templates, err := r.load()
+	if err != nil {
+		panic(err)
+	}
+	return render.HTML{Template: templates, Name: name, Data: data}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"o-neko-catnip/frontend"
+	"o-neko-catnip/pkg/config"
+	"o-neko-catnip/pkg/i18n"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LoadTemplates_ThemeOverridesBuiltinTemplatesPerFile(t *testing.T) {
+	builtin := fstest.MapFS{
+		"wakeup.html": {Data: []byte(`built-in wakeup {{ .Project.Name }}`)},
+		"error.html":  {Data: []byte(`built-in error {{ .Error }}`)},
+	}
+	theme := fstest.MapFS{
+		"wakeup.html": {Data: []byte(`{{ template "header.html" . }} themed wakeup {{ .Project.Name }}`)},
+		"header.html": {Data: []byte(`ACME`)},
+	}
+	s := &TriggerServer{configuration: &config.Config{}, i18n: newTestCatalogs(t)}
+
+	templates, err := loadTemplates(builtin, theme, s.templateFuncs())
+	assert.NoError(t, err)
+
+	var rendered bytes.Buffer
+	parameters := templateParameters{Error: "boom"}
+	parameters.Project.Name = "shop"
+	assert.NoError(t, templates.ExecuteTemplate(&rendered, "wakeup.html", parameters))
+	assert.Equal(t, "ACME themed wakeup shop", rendered.String())
+
+	rendered.Reset()
+	assert.NoError(t, templates.ExecuteTemplate(&rendered, "error.html", parameters))
+	assert.Equal(t, "built-in error boom", rendered.String())
+
+	_, err = loadTemplates(builtin, fstest.MapFS{"wakeup.html": {Data: []byte(`{{ .Project.Name`)}}, s.templateFuncs())
+	assert.Error(t, err)
+}
+
+func newTestCatalogs(t *testing.T) *i18n.Catalogs {
+	catalogs, err := i18n.New("en", frontend.Locales())
+	assert.NoError(t, err)
+	return catalogs
+}
+
+func Test_TemplateFuncs(t *testing.T) {
+	funcs := (&TriggerServer{configuration: &config.Config{}, i18n: newTestCatalogs(t)}).templateFuncs()
+	formatAsRelative := funcs["formatAsRelative"].(func(time.Time) string)
+	formatDuration := funcs["formatDuration"].(func(time.Duration) string)
+	assert.Equal(t, "just now", formatAsRelative(time.Now().Add(-10*time.Second)))
+	assert.Equal(t, "3 hours ago", formatAsRelative(time.Now().Add(-3*time.Hour-time.Minute)))
+	assert.Equal(t, "in 1 day", formatAsRelative(time.Now().Add(25*time.Hour)))
+	assert.Equal(t, "", formatAsRelative(time.Time{}))
+	assert.Equal(t, "1 minute", formatDuration(90*time.Second))
+	assert.Equal(t, "2 days", formatDuration(50*time.Hour))
+
+	joined, err := joinUrl("https://oneko.example.com/", "projects", "a b")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://oneko.example.com/projects/a%20b", joined)
+
+	withQuery, err := addQuery("https://catnip.example.com/wakeup?projectId=1", "versionId", "2&3")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://catnip.example.com/wakeup?projectId=1&versionId=2%263", withQuery)
+	_, err = addQuery("https://catnip.example.com", "versionId")
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"o-neko-catnip/pkg/deployment"
+	"o-neko-catnip/pkg/oneko/service"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getRequestedWaitDuration decides whether the client cannot use the wakeup page and wants the server to wait for the
+// deployment instead. Clients asking for JSON wait for the maximum duration, clients sending an RFC 7240 wait
+// preference for the duration they asked for.
+func getRequestedWaitDuration(r *http.Request, maxDuration time.Duration) (time.Duration, bool) {
+	if seconds, ok := getPreferredWaitSeconds(r.Header.Values("Prefer")); ok {
+		return min(time.Duration(seconds)*time.Second, maxDuration), true
+	}
+	if acceptsOnlyJson(r.Header.Get("Accept")) {
+		return maxDuration, true
+	}
+	return 0, false
+}
+
+func getPreferredWaitSeconds(preferHeaders []string) (int, bool) {
+	for _, header := range preferHeaders {
+		for _, preference := range strings.Split(header, ",") {
+			preference, _, _ = strings.Cut(preference, ";")
+			name, value, found := strings.Cut(strings.TrimSpace(preference), "=")
+			if !found || !strings.EqualFold(strings.TrimSpace(name), "wait") {
+				continue
+			}
+			seconds, err := strconv.Atoi(strings.Trim(strings.TrimSpace(value), `"`))
+			if err == nil && seconds >= 0 {
+				return seconds, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// acceptsOnlyJson is true for clients accepting JSON but no HTML, so browsers are still sent to the wakeup page.
+func acceptsOnlyJson(accept string) bool {
+	acceptsJson := false
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			acceptsJson = true
+		case "text/html", "application/xhtml+xml":
+			return false
+		}
+	}
+	return acceptsJson
+}
+
+// waitForDeployment triggers the deployment and holds the request until the deployment and the required members of
+// its groups are ready. The client is then redirected to the original URL. If the deployment does not become ready in
+// time the current status is returned.
+func (s *TriggerServer) waitForDeployment(match *service.UrlMatch, waitDuration time.Duration, c *gin.Context) {
+	protocol := getProtocol(c)
+	deploymentUrl := fmt.Sprintf("%s://%s%s", protocol, c.Request.Host, c.Request.RequestURI)
+
+	if !s.isGroupDeployed(match.Project, match.Version) {
+		if check := s.checkWakeup(match.Project, match.Version, c); !check.allowed() {
+			s.abortWithWakeupViolation(match.Project, match.Version, deploymentUrl, check, c)
+			return
+		}
+		_, err := s.wake(c, match.Project, match.Version)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	s.log.Debug("waiting for deployment", slog.String("project", match.Project.Name), slog.String("version", match.Version.Name), slog.Duration("duration", waitDuration))
+
+	c.Header("Preference-Applied", fmt.Sprintf("wait=%d", int(waitDuration.Seconds())))
+	members := s.getGroupMembersWithProbeUrls(protocol, match)
+	if err := s.waitUntilGroupReady(c.Request.Context(), members, waitDuration); err == nil {
+		c.Redirect(http.StatusTemporaryRedirect, deploymentUrl)
+		return
+	}
+
+	statuses := s.loadMemberStatuses(members)
+	status := statuses[0]
+	if status == nil {
+		status = &deployment.StatusResponse{
+			DeploymentStatus: deployment.Pending,
+		}
+	}
+	memberStatuses := s.getMemberStatuses(members[1:], statuses[1:])
+	response := statusResponse{
+		StatusResponse:  getGroupStatus(status, memberStatuses),
+		versionIdentity: newVersionIdentity(match.Project, match.Version),
+		QueuePosition:   s.capacity.QueuePosition(match.Version.Uuid),
+		Stale:           match.Stale,
+		Members:         memberStatuses,
+	}
+	response.RedirectUrl = deploymentUrl
+	response.EstimatedReadyAt = s.estimateReadyAt(response.DeploymentStatus, match.Version.Uuid)
+	c.Header("Retry-After", retryAfterSeconds)
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, response)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"o-neko-catnip/pkg/deployment"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetRequestedWaitDuration(t *testing.T) {
+	maxDuration := 2 * time.Minute
+
+	assertWait := func(expectedWait bool, expectedDuration time.Duration, headers map[string]string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		duration, wait := getRequestedWaitDuration(r, maxDuration)
+		assert.Equal(t, expectedWait, wait, headers)
+		assert.Equal(t, expectedDuration, duration, headers)
+	}
+
+	assertWait(false, 0, map[string]string{})
+	assertWait(false, 0, map[string]string{"Accept": "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"})
+	assertWait(false, 0, map[string]string{"Accept": "application/json, text/html"})
+	assertWait(false, 0, map[string]string{"Prefer": "respond-async"})
+	assertWait(false, 0, map[string]string{"Prefer": "wait=soon"})
+
+	assertWait(true, maxDuration, map[string]string{"Accept": "application/json"})
+	assertWait(true, maxDuration, map[string]string{"Accept": "application/json; charset=utf-8, */*;q=0.1"})
+	assertWait(true, 30*time.Second, map[string]string{"Prefer": "wait=30"})
+	assertWait(true, 30*time.Second, map[string]string{"Prefer": "respond-async, wait=30", "Accept": "text/html"})
+	assertWait(true, maxDuration, map[string]string{"Prefer": "wait=600"})
+}
+
+// setMaxWaitDuration limits how long clients asking for JSON are held and checks deployments more often than the
+// default monitor.
+func setMaxWaitDuration(t *testing.T, maxDuration time.Duration) {
+	waitConfig := uut.configuration.ONeko.Wait
+	uut.configuration.ONeko.Wait.MaxDuration = maxDuration
+	useFastMonitor(t)
+	t.Cleanup(func() {
+		uut.configuration.ONeko.Wait = waitConfig
+	})
+}
+
+func Test_WaitForDeployment_RedirectsOnceTheDeploymentIsReady(t *testing.T) {
+	setMaxWaitDuration(t, 5*time.Second)
+	sleepingServer.pending.Store(true)
+	t.Cleanup(func() { sleepingServer.pending.Store(false) })
+	deployCallsBefore := deployCalls.Load()
+
+	time.AfterFunc(100*time.Millisecond, func() {
+		sleepingServer.pending.Store(false)
+	})
+	recorder := requestProjectUrl(sleepingServer, "/cart?tab=checkout", map[string]string{"Accept": "application/json"})
+
+	assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+	assert.Equal(t, sleepingServer.URL+"/cart?tab=checkout", recorder.Header().Get("Location"))
+	assert.Equal(t, "wait=5", recorder.Header().Get("Preference-Applied"))
+	assert.Equal(t, deployCallsBefore+1, deployCalls.Load())
+}
+
+func Test_WaitForDeployment_ReturnsTheStatusIfTheDeploymentDoesNotBecomeReady(t *testing.T) {
+	setMaxWaitDuration(t, 100*time.Millisecond)
+
+	recorder := requestProjectUrl(startingServer, "/orders", map[string]string{"Accept": "application/json"})
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, retryAfterSeconds, recorder.Header().Get("Retry-After"))
+	var status statusResponse
+	if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status)) {
+		assert.Equal(t, deployment.Pending, status.DeploymentStatus)
+		assert.Equal(t, startingUuid, status.VersionUuid)
+		assert.Equal(t, startingServer.URL+"/orders", status.RedirectUrl)
+	}
+}
@@ -13,7 +13,7 @@ func (d *DefaultClock) Now() time.Time {
 	return time.Now()
 }
 
-func newClock() Clock {
+func NewClock() Clock {
 	return &DefaultClock{}
 }
 
@@ -22,8 +22,13 @@ type TimeMachine struct {
 }
 
 func newTimeMachine() *TimeMachine {
+	return NewTimeMachineAt(time.Now())
+}
+
+// NewTimeMachineAt returns a clock standing still at the given time until it is moved with TimeTravel.
+func NewTimeMachineAt(now time.Time) *TimeMachine {
 	return &TimeMachine{
-		currentNow: time.Now(),
+		currentNow: now,
 	}
 }
 
@@ -16,7 +16,7 @@ type Memoized[T any] struct {
 }
 
 func Memoize[T any](memoizeFor time.Duration, supplier func() (T, error)) *Memoized[T] {
-	return memoizeWithClock(memoizeFor, supplier, newClock())
+	return memoizeWithClock(memoizeFor, supplier, NewClock())
 }
 
 func memoizeWithClock[T any](memoizeFor time.Duration, supplier func() (T, error), clock Clock) *Memoized[T] {